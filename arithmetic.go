@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Integer arithmetic that also reports whether the result overflowed, which the
+// VM turns into a runtime error.
+
+func add_int64(a int64, b int64) (int64, bool) {
+	result := a + b
+	return result, (result > a) == (b > 0)
+}
+
+func sub_int64(a int64, b int64) (int64, bool) {
+	result := a - b
+	return result, (result < a) == (b > 0)
+}
+
+func mul_int64(a int64, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+
+	result := a * b
+	return result, result/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+func div_int64(a int64, b int64) (int64, bool) {
+	return a / b, !(a == math.MinInt64 && b == -1)
+}
+
+// Rounds towards negative infinity, where Go's '/' rounds towards zero.
+func floor_div_int64(a int64, b int64) (int64, bool) {
+	result, ok := div_int64(a, b)
+	if a%b != 0 && (a < 0) != (b < 0) {
+		result--
+	}
+
+	return result, ok
+}
+
+func pow_int64(base int64, exponent int64) (result int64, ok bool) {
+	result, ok = 1, true
+	for exponent > 0 {
+		var step_ok bool
+		if exponent&1 == 1 {
+			result, step_ok = mul_int64(result, base)
+			ok = ok && step_ok
+		}
+
+		exponent >>= 1
+		if exponent > 0 {
+			base, step_ok = mul_int64(base, base)
+			ok = ok && step_ok
+		}
+	}
+
+	return
+}
+
+func pow_uint64(base uint64, exponent uint64) (result uint64, ok bool) {
+	result, ok = 1, true
+	for exponent > 0 {
+		var step_ok bool
+		if exponent&1 == 1 {
+			result, step_ok = mul_uint64(result, base)
+			ok = ok && step_ok
+		}
+
+		exponent >>= 1
+		if exponent > 0 {
+			base, step_ok = mul_uint64(base, base)
+			ok = ok && step_ok
+		}
+	}
+
+	return
+}
+
+func add_uint64(a uint64, b uint64) (uint64, bool) {
+	result, carry := bits.Add64(a, b, 0)
+	return result, carry == 0
+}
+
+func sub_uint64(a uint64, b uint64) (uint64, bool) {
+	result, borrow := bits.Sub64(a, b, 0)
+	return result, borrow == 0
+}
+
+func mul_uint64(a uint64, b uint64) (uint64, bool) {
+	high, result := bits.Mul64(a, b)
+	return result, high == 0
+}
+
+// Integer division by zero and overflow are always errors, no matter if
+// arithmetic is checked or not. Decimals give Inf or NaN instead, which only
+// checked arithmetic traps.
+func check_divisor(divisor *Value) {
+	if TO_INT_S(divisor) == 0 {
+		runtime_error("Division by zero.")
+	}
+}
+
+func check_overflow(ok bool, op string, type_ ValueTypes) {
+	if !ok {
+		runtime_error("The %s result of '%s' overflowed.", ValueTypes_to_string(type_), op)
+	}
+}
+
+func (vm *VM) check_decimal(result float64, op string) float64 {
+	if vm.checked && (math.IsNaN(result) || math.IsInf(result, 0)) {
+		runtime_error("The decimal result of '%s' is %g.", op, result)
+	}
+
+	return result
+}
+
+// The amount to shift by has to be a non-negative int or uint.
+func shift_amount(amount *Value) uint64 {
+	if !IS_OF_TYPE(amount, INT) && !IS_OF_TYPE(amount, UINT) {
+		runtime_error("Cannot shift by %s, it has to be an int or uint.", ValueTypes_with_article(amount.value_type))
+	}
+
+	if IS_OF_TYPE(amount, INT) && amount.as.I64 < 0 {
+		runtime_error("Cannot shift by a negative amount.")
+	}
+
+	return TO_UINT_S(amount)
+}
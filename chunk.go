@@ -1,5 +1,9 @@
 package main
 
+import (
+	"math"
+)
+
 const (
 	OP_EOF byte = iota
 	OP_RETURN
@@ -37,17 +41,69 @@ const (
 	OP_LOAD
 	OP_START_SCOPE
 	OP_END_SCOPE
+
+	OP_IS_TYPE
+	OP_CONVERT
+
+	OP_MOD
+	OP_FLOOR_DIV
+	OP_POW
+	OP_BIT_AND
+	OP_BIT_OR
+	OP_BIT_XOR
+	OP_SHIFT_LEFT
+	OP_SHIFT_RIGHT
+
+	OP_TRY
+	OP_END_TRY
+	OP_THROW
+	OP_EPRINTLN
+	OP_READ_LINE
+	OP_PUSH_LONG
+	OP_START_MODULE
+	OP_END_MODULE
 )
 
 type Chunk struct {
 	code      []byte
 	lines     []uint32
 	constants ValueArray
+
+	// Where each constant is in 'constants', so a literal is only added once.
+	constant_indexes map[Constant_Key]uint32
+
+	// Which file the code from each offset on was compiled from.
+	files []Chunk_File
+}
+
+// The name is how the file was written in the import, the path is where it is.
+type Chunk_File struct {
+	start uint32
+	name  string
+	path  string
+}
+
+// Everything written after this comes from the file 'name'.
+func (chunk *Chunk) set_file(name string, path string) {
+	chunk.files = append(chunk.files, Chunk_File{uint32(len(chunk.code)), name, path})
+}
+
+func (chunk *Chunk) file_at(offset uint32) Chunk_File {
+	file := Chunk_File{}
+	for _, v := range chunk.files {
+		if v.start > offset {
+			break
+		}
+		file = v
+	}
+	return file
 }
 
 func (chunk *Chunk) init_chunk() {
 	chunk.code = make([]byte, 0, 0)
+	chunk.files = nil
 	init_ValueArray(&chunk.constants)
+	chunk.constant_indexes = make(map[Constant_Key]uint32)
 }
 
 func (chunk *Chunk) write_load(byte_ byte, name string, line uint32) {
@@ -58,8 +114,9 @@ func (chunk *Chunk) write_load(byte_ byte, name string, line uint32) {
 	chunk.write_chunk(OP_END_QUOTE, line)
 }
 
-func (chunk *Chunk) write_call_func(byte_ byte, name string, line uint32) {
+func (chunk *Chunk) write_call_func(byte_ byte, name string, arity byte, line uint32) {
 	chunk.write_load(byte_, name, line) // LOL
+	chunk.write_chunk(arity, line)
 }
 
 func (chunk *Chunk) write_chunk(byte_ byte, line uint32) {
@@ -85,12 +142,69 @@ func (chunk *Chunk) write_jmp(byte_ byte, jmp_value uint32, line uint32) {
 	chunk.write_chunk(byte(jmp_value), line)
 }
 
-func (chunk *Chunk) write_constant(byte_ byte, constant Value, line uint32) {
-	chunk.write_chunk(byte_, line)
-	write_ValueArray(&chunk.constants, constant)
-	index := len(chunk.constants.values) - 1
+// OP_PUSH has a 2 byte index into the constants and OP_PUSH_LONG a 3 byte one,
+// for the constants after the first 65536.
+const (
+	MAX_SHORT_CONSTANTS = 1 << 16
+	MAX_CONSTANTS       = 1 << 24
+)
+
+// How many constants a chunk can have, the tests lower it so they don't need
+// millions of them.
+var max_constants = MAX_CONSTANTS
+
+// Constants are the same when they have the same type and value. Decimals are
+// compared by their bits, so 0.0 and -0.0 are kept apart.
+type Constant_Key struct {
+	value_type ValueTypes
+	bits       uint64
+	text       string
+}
+
+func constant_key(value Value) (Constant_Key, bool) {
+	switch value.value_type {
+	case INT:
+		return Constant_Key{INT, uint64(value.as.I64), ""}, true
+	case UINT:
+		return Constant_Key{UINT, value.as.U64, ""}, true
+	case DECIMAL:
+		return Constant_Key{DECIMAL, math.Float64bits(value.as.F64), ""}, true
+	case BOOL:
+		if value.as.B1 {
+			return Constant_Key{BOOL, 1, ""}, true
+		}
+		return Constant_Key{BOOL, 0, ""}, true
+	case STRING:
+		return Constant_Key{STRING, 0, value.as.STR}, true
+	}
+	return Constant_Key{}, false
+}
+
+// Gives back false when the chunk already has max_constants constants.
+func (chunk *Chunk) write_constant(constant Value, line uint32) bool {
+	key, ok := constant_key(constant)
+	index, found := chunk.constant_indexes[key]
+	if !ok || !found {
+		if len(chunk.constants.values) >= max_constants {
+			return false
+		}
+
+		write_ValueArray(&chunk.constants, constant)
+		index = uint32(len(chunk.constants.values) - 1)
+		if ok {
+			chunk.constant_indexes[key] = index
+		}
+	}
+
+	if index < MAX_SHORT_CONSTANTS {
+		chunk.write_chunk(OP_PUSH, line)
+	} else {
+		chunk.write_chunk(OP_PUSH_LONG, line)
+		chunk.write_chunk(byte(index>>16), line)
+	}
 	chunk.write_chunk(byte(index>>8), line)
 	chunk.write_chunk(byte(index&0xff), line)
+	return true
 }
 
 func (chunk *Chunk) free_chunk() {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes the program to a file of its own, giving back its path. The functions
+// of the program are taken out of the ftable once the test is done.
+func write_program(t *testing.T, program string) string {
+	natives := len(ftable.functions)
+	t.Cleanup(func() { ftable.functions = ftable.functions[:natives] })
+
+	path := filepath.Join(t.TempDir(), "program.tesp")
+	if err := os.WriteFile(path, []byte(program), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Compiles the program, giving back the chunk and what the compiler printed.
+func compile_program(t *testing.T, interpreter *Interpreter, program string) (Chunk, bool, string) {
+	path := write_program(t, program)
+
+	var errors bytes.Buffer
+	interpreter.stderr = &errors
+	gen := interpreter.new_CodeGen()
+	chunk := gen.generate_chunk(path)
+	return chunk, gen.had_error, errors.String()
+}
+
+// The constants after the first 65536 are pushed with OP_PUSH_LONG.
+func TestLongConstants(t *testing.T) {
+	const count = MAX_SHORT_CONSTANTS + 1000
+
+	var program strings.Builder
+	program.WriteString("(println (+")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&program, " %d", i)
+	}
+	program.WriteString("))")
+
+	var output bytes.Buffer
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	interpreter.stdout = &output
+	chunk, had_error, errors := compile_program(t, interpreter, program.String())
+	if had_error {
+		t.Fatalf("The program didn't compile:\n%s", errors)
+	}
+	if len(chunk.constants.values) != count {
+		t.Errorf("The chunk has %d constants, expected %d.", len(chunk.constants.values), count)
+	}
+
+	vm := interpreter.new_VM(&chunk)
+	defer free_VM(&vm)
+
+	env = new_Environment()
+	if result := run(&vm); result != INTERPRETER_RESULT_OK {
+		t.Fatalf("The program failed with %v.", vm.err)
+	}
+	if want := fmt.Sprintln(count * (count - 1) / 2); output.String() != want {
+		t.Errorf("The program printed %q, expected %q.", output.String(), want)
+	}
+}
+
+func TestTooManyConstants(t *testing.T) {
+	defer func(max int) { max_constants = max }(max_constants)
+	max_constants = 3
+
+	_, had_error, errors := compile_program(t, new_Interpreter(PERMISSION_PURE), "(println (+ 1 2 3 4))")
+	if !had_error {
+		t.Fatal("A program with more constants than the most a chunk can have compiled.")
+	}
+	if want := "Error at 4: Too many constants in one chunk, the most is 3."; !strings.Contains(errors, want) {
+		t.Errorf("The compiler printed %q, expected it to have %q.", errors, want)
+	}
+}
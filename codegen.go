@@ -3,17 +3,51 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type CodeGen struct {
+	lexer              *Lexer
 	current            Token
 	previous           Token
 	chunk              Chunk
 	had_error          bool
 	panic_mode         bool
 	generate_EOF_token bool
+	strict             bool
+	function_depth     int
+	limits             Limits
+	permissions        Permissions
+
+	// The module being compiled, every compiled module by its path, the chain of
+	// imports that lead to the current module and where to look for modules.
+	module      *Module
+	modules     map[string]*Module
+	importing   []*Module
+	search_path []string
+
+	// The variables in scope and the type of the last expression, as far as the
+	// compiler can tell. The value is only there when the expression was a literal.
+	variables        []Compiled_Variable
+	scope_depth      int
+	function_scopes  []int
+	expression_type  ValueTypes
+	expression_value *Value
+
+	// How many branches, loops and try blocks the code being compiled is in,
+	// as it might not run or run more than once.
+	branch_depth int
+
+	// The tests of the script, not the ones of the modules it imports.
+	tests []Test_Case
+
+	// Where the errors get printed.
+	errors io.Writer
 }
 
 func (gen *CodeGen) error_at(token *Token, msg string) {
@@ -22,17 +56,21 @@ func (gen *CodeGen) error_at(token *Token, msg string) {
 	}
 
 	gen.panic_mode = true
-	fmt.Printf("[Line: %d] Error", token.line)
+	if gen.module != nil && len(gen.importing) > 1 {
+		fmt.Fprintf(gen.errors, "[%s Line: %d] Error", gen.module.name, token.line)
+	} else {
+		fmt.Fprintf(gen.errors, "[Line: %d] Error", token.line)
+	}
 
 	if is_Token_of_type(*token, TOKEN_EOF) {
-		fmt.Printf(" at end")
+		fmt.Fprintf(gen.errors, " at end")
 	} else if is_Token_of_type(*token, TOKEN_ERROR) {
 
 	} else {
-		fmt.Printf(" at %s", token.lexeme)
+		fmt.Fprintf(gen.errors, " at %s", token.lexeme)
 	}
 
-	log.Printf(": %s\n", msg)
+	fmt.Fprintf(gen.errors, ": %s\n", msg)
 	gen.had_error = true
 }
 
@@ -48,7 +86,7 @@ func (gen *CodeGen) advance_g() {
 	gen.previous = gen.current
 
 	for {
-		gen.current = scan_token()
+		gen.current = gen.lexer.Next()
 		if gen.current.t_type != TOKEN_ERROR {
 			break
 		}
@@ -70,8 +108,15 @@ func (gen *CodeGen) emit_byte(byte_ byte) {
 	gen.chunk.write_chunk(byte_, uint32(gen.previous.line))
 }
 
+func (gen *CodeGen) emit_bytes(byte1 byte, byte2 byte) {
+	gen.emit_byte(byte1)
+	gen.emit_byte(byte2)
+}
+
 func (gen *CodeGen) emit_constant(value Value) {
-	gen.chunk.write_constant(OP_PUSH, value, uint32(gen.previous.line))
+	if !gen.chunk.write_constant(value, uint32(gen.previous.line)) {
+		gen.error_at_current(fmt.Sprintf("Too many constants in one chunk, the most is %d.", max_constants))
+	}
 }
 
 func (gen *CodeGen) emit_jmp(op byte, index uint32) {
@@ -90,8 +135,8 @@ func (gen *CodeGen) emit_assign(name string) {
 	gen.chunk.write_load(OP_ASSIGN, name, uint32(gen.previous.line))
 }
 
-func (gen *CodeGen) emit_call_func(name string) {
-	gen.chunk.write_call_func(OP_CALL_FUNC, name, uint32(gen.previous.line))
+func (gen *CodeGen) emit_call_func(name string, arity byte) {
+	gen.chunk.write_call_func(OP_CALL_FUNC, name, arity, uint32(gen.previous.line))
 }
 
 func (gen *CodeGen) literals() {
@@ -99,38 +144,126 @@ func (gen *CodeGen) literals() {
 }
 
 func (gen *CodeGen) compile_literal(literal Token) {
-	if literal.t_type == TOKEN_TRUE {
-		gen.emit_constant(BOOL_VAL(true))
-		return
-	} else if literal.t_type == TOKEN_FALSE {
-		gen.emit_constant(BOOL_VAL(false))
-		return
+	if value, ok := gen.literal_value(literal); ok {
+		gen.emit_constant(value)
+		gen.expression_type, gen.expression_value = value.value_type, &value
+	}
+}
+
+// Splits the lexeme of an int or uint literal into its sign and digits, without
+// the underscores, and the base they are written in.
+func integer_literal_digits(lexeme string) (string, int) {
+	sign := ""
+	if strings.HasPrefix(lexeme, "-") {
+		sign, lexeme = "-", lexeme[1:]
 	}
 
+	base := 10
+	if len(lexeme) > 2 && lexeme[0] == '0' {
+		switch lexeme[1] {
+		case 'x':
+			base = 16
+		case 'b':
+			base = 2
+		case 'o':
+			base = 8
+		}
+
+		if base != 10 {
+			lexeme = lexeme[2:]
+		}
+	}
+
+	return sign + strings.ReplaceAll(lexeme, "_", ""), base
+}
+
+// Gives back the value of a literal token, reporting literals that are out of
+// range for their type.
+func (gen *CodeGen) literal_value(literal Token) (Value, bool) {
 	switch literal.t_type {
+	case TOKEN_TRUE:
+		return BOOL_VAL(true), true
+
+	case TOKEN_FALSE:
+		return BOOL_VAL(false), true
+
 	case TOKEN_UINT:
-		value, err := strconv.ParseUint(literal.lexeme, 10, 64)
+		digits, base := integer_literal_digits(literal.lexeme)
+		value, err := strconv.ParseUint(digits, base, 64)
 		if err != nil {
-			log.Panicln("Failed to convert value to uint")
+			gen.error_at(&literal, "The number is out of range for a uint.")
 		}
-		gen.emit_constant(UINT_VAL(value))
+		return UINT_VAL(value), true
 
 	case TOKEN_INT:
-		value, err := strconv.ParseInt(literal.lexeme, 10, 64)
+		digits, base := integer_literal_digits(literal.lexeme)
+		value, err := strconv.ParseInt(digits, base, 64)
 		if err != nil {
-			log.Panicln("Failed to convert value to int")
+			gen.error_at(&literal, "The number is out of range for an int, add a 'u' to make it a uint.")
 		}
-		gen.emit_constant(INT_VAL(value))
+		return INT_VAL(value), true
 
 	case TOKEN_DECIMAL:
-		value, err := strconv.ParseFloat(literal.lexeme, 10)
+		value, err := strconv.ParseFloat(strings.ReplaceAll(literal.lexeme, "_", ""), 64)
 		if err != nil {
-			log.Panicln("Failed to convert value to decimal")
+			gen.error_at(&literal, "The number is out of range for a decimal.")
 		}
-		gen.emit_constant(DECIMAL_VAL(value))
+		return DECIMAL_VAL(value), true
 
 	case TOKEN_STRING:
-		gen.emit_constant(STRING_VAL(literal.lexeme))
+		return STRING_VAL(literal.lexeme), true
+	}
+
+	return NO_VAL(), false
+}
+
+func type_from_Token(t_type Token_Type) (ValueTypes, bool) {
+	switch t_type {
+	case TOKEN_TYPE_INT:
+		return INT, true
+
+	case TOKEN_TYPE_UINT:
+		return UINT, true
+
+	case TOKEN_TYPE_STRING:
+		return STRING, true
+
+	case TOKEN_TYPE_BOOL:
+		return BOOL, true
+
+	case TOKEN_TYPE_DECIMAL:
+		return DECIMAL, true
+
+	case TOKEN_TYPE_ANY:
+		return ANY, true
+	}
+
+	return NO_VALUE, false
+}
+
+// Strict mode makes implicit conversions that lose information compile errors,
+// where the value is known at compile time. Everything else is checked by the VM.
+func (gen *CodeGen) check_strict_conversion(token *Token, value *Value, value_type ValueTypes) {
+	if is_lossy_conversion(value, value_type) {
+		gen.error_at(token, fmt.Sprintf("Strict mode doesn't allow implicitly converting %s to %s, use '(%s x)' instead.",
+			ValueTypes_with_article(value.value_type), ValueTypes_with_article(value_type), ValueTypes_to_string(value_type)))
+	}
+}
+
+// Checks the arguments of a call to a function that is already known, like
+// 'var' checks its value. What only the VM can tell is left to it.
+func (gen *CodeGen) check_strict_arguments(token *Token, function *Function_Entry, types []ValueTypes, values []*Value) {
+	for i, value_type := range types {
+		arg_type := function.rest_type
+		if i < len(function.arg_types) {
+			arg_type = function.arg_types[i]
+		}
+
+		value := Value{value_type: value_type}
+		if values[i] != nil {
+			value = *values[i]
+		}
+		gen.check_strict_conversion(token, &value, arg_type)
 	}
 }
 
@@ -171,6 +304,7 @@ func (gen *CodeGen) lists() {
 
 	case TOKEN_VAR:
 		gen.advance_g()
+		name_token := gen.current
 		name := gen.current.lexeme
 		gen.consume(TOKEN_IDENTIFER, "Expected an identifer after 'var'")
 
@@ -178,41 +312,37 @@ func (gen *CodeGen) lists() {
 		amount := len(gen.chunk.code)
 		gen.expression()
 		if amount < len(gen.chunk.code) {
-			gen.emit_byte(OP_EOF)
-			vm := new_VM(&gen.chunk)
+			value_type := gen.expression_type
+			if value_type == NO_VALUE {
+				value_type = ANY
+			}
 
-			value_type := vm.evaluate_operation()
-			gen.chunk.code = gen.chunk.code[0 : len(gen.chunk.code)-1]
 			gen.emit_store(name, value_type)
+			gen.declare_variable(name, value_type)
 			break
 		}
 
 		gen.advance_g()
 		gen.expression()
 
-		var value_type ValueTypes
-
-		switch literal_type {
-		case TOKEN_TYPE_INT:
-			value_type = INT
-
-		case TOKEN_TYPE_UINT:
-			value_type = UINT
-
-		case TOKEN_TYPE_STRING:
-			value_type = STRING
-
-		case TOKEN_TYPE_BOOL:
-			value_type = BOOL
-
-		case TOKEN_TYPE_DECIMAL:
-			value_type = DECIMAL
-
-		default:
+		value_type, ok := type_from_Token(literal_type)
+		if !ok {
 			gen.error_at_current("Expected a type to be specified")
 		}
 
+		// Without a literal only the type is known, which is enough to tell a
+		// decimal can't be stored in an int. A uint or int that doesn't fit is
+		// reported by the VM.
+		if gen.strict {
+			value := Value{value_type: gen.expression_type}
+			if gen.expression_value != nil {
+				value = *gen.expression_value
+			}
+			gen.check_strict_conversion(&name_token, &value, value_type)
+		}
+
 		gen.emit_store(name, value_type)
+		gen.declare_variable(name, value_type)
 
 	case TOKEN_IF:
 		gen.advance_g()
@@ -223,7 +353,8 @@ func (gen *CodeGen) lists() {
 			gen.expression()
 		}
 
-		gen.emit_byte(OP_START_SCOPE)
+		gen.begin_scope()
+		gen.branch_depth++
 		patch_area := gen.generate_patch_jmp(OP_IF_FALSE_JMP)
 		if gen.current.t_type != TOKEN_LEFT_PAREN {
 			gen.expression()
@@ -241,42 +372,68 @@ func (gen *CodeGen) lists() {
 			gen.expression()
 		}
 		gen.patch_jump(else_patch_area, uint32(len(gen.chunk.code)))
-		gen.emit_byte(OP_END_SCOPE)
+		gen.branch_depth--
+		gen.end_scope()
 
 	case TOKEN_FUNC:
 		gen.advance_g()
-		name := gen.current.lexeme
+		name_token := gen.current
 		gen.consume(TOKEN_IDENTIFER, "Expected an identifer after 'func'.")
 
+		name := gen.module.qualify(name_token.lexeme)
+		if strings.Contains(name_token.lexeme, ".") {
+			gen.error_at(&name_token, "The name of a function cannot contain a '.'.")
+		} else if ftable.check_if_already_exists(name) {
+			gen.error_at(&name_token, fmt.Sprintf("Function '%s' already exists.", name_token.lexeme))
+		}
+
 		gen.consume(TOKEN_LEFT_BRACKET, "Expected '[' before function arguments.")
 		var function_args []string
 		var function_types []ValueTypes
+		var defaults []Value
+		variadic := false
+		rest_type := NO_VALUE
+
+		for gen.current.t_type != TOKEN_RIGHT_BRACKET && gen.current.t_type != TOKEN_EOF {
+			if variadic {
+				gen.error_at_current("The rest argument has to be the last function argument.")
+				break
+			}
+
+			if gen.current.t_type == TOKEN_ELLIPSIS {
+				gen.advance_g()
+				variadic = true
+			}
 
-		for gen.current.t_type != TOKEN_RIGHT_BRACKET {
+			arg_token := gen.current
 			arg_name := gen.current.lexeme
 			gen.consume(TOKEN_IDENTIFER, "Expected an identifer for function argument.")
-			literal_type := gen.current.t_type
+			value_type, ok := type_from_Token(gen.current.t_type)
+			if !ok {
+				gen.error_at_current("Expected a type to be specified")
+			}
 			gen.advance_g()
-			var value_type ValueTypes
-
-			switch literal_type {
-			case TOKEN_TYPE_INT:
-				value_type = INT
 
-			case TOKEN_TYPE_UINT:
-				value_type = UINT
-
-			case TOKEN_TYPE_STRING:
-				value_type = STRING
-
-			case TOKEN_TYPE_BOOL:
-				value_type = BOOL
-
-			case TOKEN_TYPE_DECIMAL:
-				value_type = DECIMAL
-
-			default:
-				gen.error_at_current("Expected a type to be specified")
+			if variadic {
+				rest_type = value_type
+				value_type = LIST
+			} else if gen.current.t_type == TOKEN_EQUAL {
+				gen.advance_g()
+				default_value, ok := gen.literal_value(gen.current)
+				gen.advance_g()
+				if !ok {
+					gen.error_at_previous("Expected a literal as the default value.")
+				} else if value_type != ANY && default_value.value_type != value_type && promote_types(default_value.value_type, value_type) > DECIMAL {
+					gen.error_at_previous(fmt.Sprintf("The default value of '%s' is %s, which cannot be converted to %s.",
+						arg_name, ValueTypes_with_article(default_value.value_type), ValueTypes_with_article(value_type)))
+				} else {
+					if gen.strict {
+						gen.check_strict_conversion(&arg_token, &default_value, value_type)
+					}
+					defaults = append(defaults, coerce_Value(default_value, value_type))
+				}
+			} else if len(defaults) > 0 {
+				gen.error_at_previous("Expected a default value, as the argument before it has one.")
 			}
 
 			function_args = append(function_args, arg_name)
@@ -288,66 +445,172 @@ func (gen *CodeGen) lists() {
 		}
 
 		gen.consume(TOKEN_RIGHT_BRACKET, "Expected ']' after function arguments.")
-		literal_type := gen.current.t_type
+
+		min_arity := uint(len(function_args) - len(defaults))
+		max_arity := uint(len(function_args))
+		if variadic {
+			min_arity--
+			max_arity = ARITY_UNLIMITED
+		}
+
+		return_type, has_return_type := type_from_Token(gen.current.t_type)
 
 		var skip_over_function = gen.generate_patch_jmp(OP_JMP)
 		var function_position = len(gen.chunk.code)
-		gen.emit_byte(OP_START_SCOPE)
-		amount := len(gen.chunk.code)
 
-		calculated_argument_amount := 0
+		// Added before the body, so calls to itself can be checked.
+		if !ftable.check_if_already_exists(name) {
+			arg_types := function_types
+			if variadic {
+				arg_types = function_types[0 : len(function_types)-1]
+			}
+			ftable.add_virtual_entry(name, gen.module.prefix, uint(function_position), min_arity, max_arity, defaults, arg_types, rest_type, return_type)
+			gen.module.functions[name_token.lexeme] = true
+		}
+
+		gen.begin_scope()
+		gen.begin_function()
 		for i, v := range function_args {
-			calculated_argument_amount += len(v) + 3
 			gen.chunk.write_store(OP_STORE, v, function_types[i], uint32(gen.previous.line))
+			gen.declare_variable(v, function_types[i])
 		}
-		gen.expression()
 
-		if amount < len(gen.chunk.code)-calculated_argument_amount {
+		if !has_return_type {
+			gen.expression()
+			gen.end_function()
 			gen.emit_byte(OP_RETURN)
-			gen.emit_byte(OP_END_SCOPE)
+			gen.end_scope()
 			gen.patch_jump(skip_over_function, uint32(len(gen.chunk.code)))
-
-			ftable.add_virtual_entry(name, uint(function_position), uint(len(function_args)), NO_VALUE)
 			break
 		}
 
 		gen.advance_g()
 		gen.expression()
-		gen.emit_byte(OP_END_SCOPE)
+		gen.end_function()
+		gen.end_scope()
 		gen.patch_jump(skip_over_function, uint32(len(gen.chunk.code)))
 
-		var value_type ValueTypes
+	case TOKEN_TEST:
+		// (test "name" body), the body is a function without arguments that
+		// only 'tesp test' calls.
+		gen.advance_g()
+		name_token := gen.current
+		gen.consume(TOKEN_STRING, "Expected the name of the test after 'test'.")
+
+		name := gen.module.qualify("test " + name_token.lexeme)
+		if gen.function_depth > 0 {
+			gen.error_at(&name_token, "Tests can only be defined outside of functions.")
+		} else if ftable.check_if_already_exists(name) {
+			gen.error_at(&name_token, fmt.Sprintf("Test '%s' already exists.", name_token.lexeme))
+		}
 
-		switch literal_type {
-		case TOKEN_TYPE_INT:
-			value_type = INT
+		var skip_over_test = gen.generate_patch_jmp(OP_JMP)
+		if !ftable.check_if_already_exists(name) {
+			ftable.add_virtual_entry(name, gen.module.prefix, uint(len(gen.chunk.code)), 0, 0, nil, nil, NO_VALUE, NO_VALUE)
+			if len(gen.importing) == 1 {
+				gen.tests = append(gen.tests, Test_Case{name_token.lexeme, name, uint32(name_token.line)})
+			}
+		}
+
+		gen.begin_scope()
+		gen.begin_function()
+		gen.expression()
+		gen.end_function()
+		gen.emit_byte(OP_RETURN)
+		gen.end_scope()
+		gen.patch_jump(skip_over_test, uint32(len(gen.chunk.code)))
 
-		case TOKEN_TYPE_UINT:
-			value_type = UINT
+	case TOKEN_TRY:
+		// (try body (catch name handler)), the handler gets the thrown value
+		// or the message of the runtime error as 'name'.
+		gen.advance_g()
+		try_patch := gen.generate_patch_jmp(OP_TRY)
+		gen.branch_depth++
 
-		case TOKEN_TYPE_STRING:
-			value_type = STRING
+		gen.begin_scope()
+		if gen.current.t_type != TOKEN_LEFT_PAREN {
+			gen.expression()
+			gen.advance_g()
+		} else {
+			gen.expression()
+		}
+		gen.end_scope()
+		gen.emit_byte(OP_END_TRY)
 
-		case TOKEN_TYPE_BOOL:
-			value_type = BOOL
+		end_patch := gen.generate_patch_jmp(OP_JMP)
+		gen.patch_jump(try_patch, uint32(len(gen.chunk.code)))
 
-		case TOKEN_TYPE_DECIMAL:
-			value_type = DECIMAL
+		gen.consume(TOKEN_LEFT_PAREN, "Expected '(catch name handler)' after the body of 'try'.")
+		gen.consume(TOKEN_CATCH, "Expected 'catch' after the body of 'try'.")
+		name := gen.current.lexeme
+		gen.consume(TOKEN_IDENTIFER, "Expected a name for the error after 'catch'.")
 
-		default:
-			gen.error_at_current("Expected a type to be specified")
+		gen.begin_scope()
+		gen.emit_store(name, ANY)
+		gen.declare_variable(name, ANY)
+		if gen.current.t_type != TOKEN_LEFT_PAREN {
+			gen.expression()
+			gen.advance_g()
+		} else {
+			gen.expression()
 		}
+		gen.end_scope()
+		gen.branch_depth--
+		gen.consume(TOKEN_RIGHT_PAREN, "Expected ')' after the handler of 'catch'.")
 
-		ftable.add_virtual_entry(name, uint(function_position), uint(len(function_args)), value_type)
+		gen.patch_jump(end_patch, uint32(len(gen.chunk.code)))
+
+	case TOKEN_THROW:
+		gen.advance_g()
+		amount := len(gen.chunk.code)
+		if gen.current.t_type != TOKEN_LEFT_PAREN {
+			gen.expression()
+			gen.advance_g()
+		} else {
+			gen.expression()
+		}
+
+		if amount == len(gen.chunk.code) {
+			gen.error_at(&first_token, fmt.Sprintf("Expected a value to '%s'.", first_token.lexeme))
+		}
+		gen.emit_byte(OP_THROW)
+
+	case TOKEN_IMPORT:
+		gen.advance_g()
+		path_token := gen.current
+		gen.consume(TOKEN_STRING, "Expected the path of the module after 'import'.")
+		gen.consume(TOKEN_AS, "Expected 'as' after the path of the module.")
+		alias_token := gen.current
+		gen.consume(TOKEN_IDENTIFER, "Expected a name for the module after 'as'.")
+
+		gen.import_module(&path_token, &alias_token)
+
+	case TOKEN_IS:
+		gen.advance_g()
+		if gen.current.t_type != TOKEN_LEFT_PAREN {
+			gen.expression()
+			gen.advance_g()
+		} else {
+			gen.expression()
+		}
+
+		value_type, ok := type_from_Token(gen.current.t_type)
+		if !ok {
+			gen.error_at_current("Expected a type to check against after the value.")
+		}
+		gen.emit_bytes(OP_IS_TYPE, byte(value_type))
 
 	case TOKEN_LEFT_PAREN:
-		for gen.current.t_type != TOKEN_RIGHT_PAREN {
+		// An unclosed list ends at the end of the file, where the consume
+		// below reports it.
+		for gen.current.t_type != TOKEN_RIGHT_PAREN && gen.current.t_type != TOKEN_EOF {
 			gen.expression()
 		}
 
 	case TOKEN_WHILE:
 		gen.advance_g()
 		jmp_area := len(gen.chunk.code)
+		gen.branch_depth++
 		if gen.current.t_type != TOKEN_LEFT_PAREN {
 			gen.expression()
 			gen.advance_g()
@@ -355,12 +618,12 @@ func (gen *CodeGen) lists() {
 			gen.expression()
 		}
 
-		if jmp_area >= len(gen.chunk.code) {
+		if jmp_area >= len(gen.chunk.code) && !gen.had_error {
 			log.Panicln("Expected an expression after 'while'")
 		}
 		condition_if := gen.generate_patch_jmp(OP_IF_FALSE_JMP)
 
-		gen.emit_byte(OP_START_SCOPE)
+		gen.begin_scope()
 		amount := len(gen.chunk.code)
 		if gen.current.t_type != TOKEN_LEFT_PAREN {
 			gen.expression()
@@ -369,25 +632,32 @@ func (gen *CodeGen) lists() {
 			gen.expression()
 		}
 
-		if amount >= len(gen.chunk.code) {
+		if amount >= len(gen.chunk.code) && !gen.had_error {
 			log.Panicln("Expected expression body after condition.")
 		}
-		gen.emit_byte(OP_END_SCOPE)
+		gen.end_scope()
+		gen.branch_depth--
 		gen.emit_jmp(OP_JMP, uint32(jmp_area))
 		gen.patch_jump(condition_if, uint32(len(gen.chunk.code)))
 	}
 
 	arguments := 0
+	var argument_types []ValueTypes
+	var argument_values []*Value
 
 	for gen.current.t_type != TOKEN_RIGHT_PAREN {
 		gen.advance_g()
 		for gen.current.t_type == TOKEN_LEFT_PAREN {
 			gen.expression()
 			arguments += 1
+			argument_types = append(argument_types, gen.expression_type)
+			argument_values = append(argument_values, gen.expression_value)
 		}
 		gen.expression()
 
 		arguments += 1
+		argument_types = append(argument_types, gen.expression_type)
+		argument_values = append(argument_values, gen.expression_value)
 
 		if gen.current.t_type == TOKEN_EOF {
 			break
@@ -397,6 +667,13 @@ func (gen *CodeGen) lists() {
 	//fmt.Println("ARGUMENTS:", arguments)
 	//fmt.Println("TYPE:", first_token.t_type)
 
+	// Like the arity, the last iteration doesn't read an argument.
+	if len(argument_types) > 0 {
+		argument_types = argument_types[0 : len(argument_types)-1]
+		argument_values = argument_values[0 : len(argument_values)-1]
+	}
+	function := ""
+
 	switch first_token.t_type {
 	case TOKEN_PRINT:
 		gen.emit_byte(OP_PRINT)
@@ -404,12 +681,25 @@ func (gen *CodeGen) lists() {
 	case TOKEN_PRINTLN:
 		gen.emit_byte(OP_PRINTLN)
 
+	case TOKEN_EPRINTLN:
+		gen.emit_byte(OP_EPRINTLN)
+
+	case TOKEN_READ_LINE:
+		if arguments > 1 {
+			gen.error_at(&first_token, "'read-line' doesn't take any arguments.")
+		}
+		gen.emit_byte(OP_READ_LINE)
+
 	case TOKEN_PLUS:
 		for i := 0; i < arguments-2; i++ {
 			gen.emit_byte(OP_ADD)
 		}
 
 	case TOKEN_MINUS:
+		if arguments-1 == 1 {
+			gen.emit_byte(OP_NEGATE)
+		}
+
 		for i := 0; i < arguments-2; i++ {
 			gen.emit_byte(OP_SUB)
 		}
@@ -424,6 +714,46 @@ func (gen *CodeGen) lists() {
 			gen.emit_byte(OP_DIV)
 		}
 
+	case TOKEN_DIV:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_FLOOR_DIV)
+		}
+
+	case TOKEN_PERCENT:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_MOD)
+		}
+
+	case TOKEN_STAR_STAR:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_POW)
+		}
+
+	case TOKEN_AMPERSAND:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_BIT_AND)
+		}
+
+	case TOKEN_PIPE:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_BIT_OR)
+		}
+
+	case TOKEN_CARET:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_BIT_XOR)
+		}
+
+	case TOKEN_LESS_LESS:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_SHIFT_LEFT)
+		}
+
+	case TOKEN_GREATER_GREATER:
+		for i := 0; i < arguments-2; i++ {
+			gen.emit_byte(OP_SHIFT_RIGHT)
+		}
+
 	case TOKEN_LESS:
 		for i := 0; i < arguments-2; i++ {
 			gen.emit_byte(OP_CMP_LESS)
@@ -454,41 +784,129 @@ func (gen *CodeGen) lists() {
 			gen.emit_byte(OP_CMP_NOT_EQUAL)
 		}
 
+	case TOKEN_TYPE_INT, TOKEN_TYPE_UINT, TOKEN_TYPE_DECIMAL, TOKEN_TYPE_STRING, TOKEN_TYPE_BOOL:
+		if arguments-1 != 1 {
+			gen.error_at(&first_token, "Expected exactly one value to convert.")
+		}
+
+		value_type, _ := type_from_Token(first_token.t_type)
+		gen.emit_bytes(OP_CONVERT, byte(value_type))
+
 	case TOKEN_IDENTIFER:
-		gen.emit_call_func(first_token.lexeme)
+		// The last iteration of the loop above doesn't read an argument.
+		arity := arguments - 1
+		if arity > 255 {
+			gen.error_at(&first_token, "Cannot call a function with more than 255 arguments.")
+		}
+
+		name := gen.resolve_function(&first_token)
+		function = name
+
+		// Functions that haven't been defined yet get checked at runtime instead.
+		if ftable.check_if_already_exists(name) {
+			function := ftable.get_entry(name)
+			if function.f_type == FUNCTION_NATIVE && !gen.permissions.allows(function.permission) {
+				gen.error_at(&first_token, fmt.Sprintf("'%s' needs the %s permission, which this script doesn't have.", name, function.permission))
+			} else if !function.accepts_arity(uint(arity)) {
+				gen.error_at(&first_token, fmt.Sprintf("Function expects %s, but got %d.", function.arity_to_string(), arity))
+			} else if gen.strict {
+				gen.check_strict_arguments(&first_token, &function, argument_types, argument_values)
+			}
+		}
+
+		gen.emit_call_func(name, byte(arity))
 
 	case TOKEN_RETURN:
 		gen.emit_byte(OP_RETURN)
 	}
 
 	gen.consume(TOKEN_RIGHT_PAREN, "Expected ')' after list.")
+	gen.expression_type, gen.expression_value = list_type(&first_token, function, argument_types), nil
 }
 
 func (gen *CodeGen) identifer_g() {
+	if strings.Contains(gen.current.lexeme, ".") {
+		gen.error_at_current("Only the functions of a module can be used.")
+	}
 	gen.emit_load(gen.current.lexeme)
 	//gen.consume(TOKEN_IDENTIFER, "Expected an identifer.")
 }
 
+// Compiles an interpolated string into concatenations, where the adds convert
+// each expression to a string. Just like a literal, this leaves the last part
+// of the string as the current token.
+func (gen *CodeGen) interpolation() {
+	gen.emit_constant(STRING_VAL(gen.current.lexeme))
+
+	for gen.current.t_type == TOKEN_INTERPOLATION || gen.current.t_type == TOKEN_INTERPOLATION_MIDDLE {
+		gen.advance_g()
+		if gen.current.t_type == TOKEN_INTERPOLATION_MIDDLE || gen.current.t_type == TOKEN_INTERPOLATION_END {
+			gen.error_at_current("Expected an expression inside of '${}'.")
+			return
+		}
+
+		if gen.current.t_type != TOKEN_LEFT_PAREN {
+			gen.expression()
+			gen.advance_g()
+		} else {
+			gen.expression()
+		}
+		gen.emit_byte(OP_ADD)
+
+		if gen.current.t_type != TOKEN_INTERPOLATION_MIDDLE && gen.current.t_type != TOKEN_INTERPOLATION_END {
+			gen.error_at_current("Expected '}' after the interpolated expression.")
+			return
+		}
+
+		if gen.current.lexeme != "" {
+			gen.emit_constant(STRING_VAL(gen.current.lexeme))
+			gen.emit_byte(OP_ADD)
+		}
+	}
+}
+
 func (gen *CodeGen) expression() {
+	gen.expression_type, gen.expression_value = NO_VALUE, nil
+
 	switch gen.current.t_type {
 	case TOKEN_IDENTIFER:
 		gen.identifer_g()
+		gen.expression_type = gen.variable_type(gen.current.lexeme)
 	case TOKEN_UINT, TOKEN_INT, TOKEN_FALSE, TOKEN_TRUE, TOKEN_DECIMAL, TOKEN_STRING:
 		gen.literals()
+	case TOKEN_INTERPOLATION:
+		gen.interpolation()
+		gen.expression_type, gen.expression_value = STRING, nil
 	case TOKEN_LEFT_PAREN:
 		gen.lists()
 	}
 }
 
 func (gen *CodeGen) generate_chunk(file_path string) Chunk {
-	new_Scanner(file_path)
+	lexer, err := new_Lexer_from_file(file_path)
+	if err != nil {
+		fmt.Fprintf(gen.errors, "Cannot read the script: %s\n", err)
+		gen.had_error = true
+		return gen.chunk
+	}
+	gen.lexer = lexer
+
+	path, err := filepath.Abs(file_path)
+	if err != nil {
+		path = file_path
+	}
+	gen.module = new_Module(file_path, path, "")
+	gen.module.compiling = true
+	gen.modules[path] = gen.module
+	gen.importing = append(gen.importing, gen.module)
+	gen.chunk.set_file(gen.module.name, gen.module.path)
 
+	// The variables of the script stay once it's done, so the tests get them.
 	gen.advance_g()
-	gen.emit_byte(OP_START_SCOPE)
+	gen.begin_scope()
 	for gen.current.t_type != TOKEN_EOF {
 		gen.expression()
 	}
-	gen.emit_byte(OP_END_SCOPE)
 
 	gen.consume(TOKEN_EOF, "Expected end of expression.")
 
@@ -502,6 +920,10 @@ func new_CodeGen(generate_EOF_token bool) CodeGen {
 	gen := CodeGen{}
 	gen.chunk.init_chunk()
 	gen.generate_EOF_token = generate_EOF_token
+	// Only the pure natives, anything more has to be allowed by the Interpreter.
+	gen.permissions = PERMISSION_PURE
+	gen.modules = make(map[string]*Module)
+	gen.errors = os.Stderr
 
 	return gen
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+// Each command gets the arguments after its name and gives back the exit code.
+
+// The flags of every command that runs scripts.
+type interpreter_flags struct {
+	strict           *bool
+	checked          *bool
+	path             *string
+	allow            *string
+	max_instructions *uint64
+	timeout          *time.Duration
+	max_stack        *int
+	max_variables    *int
+	max_call_depth   *int
+	max_bytes        *uint64
+	trace            *string
+	trace_format     *string
+	trace_function   *string
+	trace_lines      *string
+}
+
+func add_interpreter_flags(flags *flag.FlagSet) *interpreter_flags {
+	return &interpreter_flags{
+		flags.Bool("strict", false, "Make implicit conversions that lose information errors."),
+		flags.Bool("checked", false, "Make NaN or Inf decimals and bits shifted off an integer errors."),
+		flags.String("path", "", "Directories to look for modules in, separated like PATH. TESP_PATH is searched after them."),
+		flags.String("allow", "pure,time", "The natives the script can call: all or a list of pure, time, filesystem, environment, process and net."),
+		flags.Uint64("max-instructions", 0, "Stop the script after this many instructions, 0 means no limit."),
+		flags.Duration("timeout", 0, "Stop the script after it has been running for this long, 0 means no limit."),
+		flags.Int("max-stack", 0, "The most values the stack can hold, 0 means no limit."),
+		flags.Int("max-variables", 0, "The most variables that can be alive at once, 0 means no limit."),
+		flags.Int("max-call-depth", 0, "How deep function calls can go, 0 means no limit."),
+		flags.Uint64("max-bytes", 0, "The most bytes that can be allocated for strings and lists, 0 means no limit."),
+		flags.String("trace", "", "Write every instruction that runs to this file, - means stderr."),
+		flags.String("trace-format", "text", "How to write the trace: text or json."),
+		flags.String("trace-function", "", "Only trace this function, script means the code outside of functions."),
+		flags.String("trace-lines", "", "Only trace these lines, like 10-20."),
+	}
+}
+
+func (f *interpreter_flags) new_Interpreter(ctx context.Context) (*Interpreter, error) {
+	permissions, err := parse_Permissions(*f.allow)
+	if err != nil {
+		return nil, err
+	}
+
+	interpreter := new_Interpreter(permissions)
+	interpreter.strict = *f.strict
+	interpreter.checked = *f.checked
+	interpreter.search_path = append(filepath.SplitList(*f.path), filepath.SplitList(os.Getenv("TESP_PATH"))...)
+	interpreter.limits = Limits{*f.max_instructions, time.Time{}, ctx, *f.max_stack, *f.max_variables, *f.max_call_depth, *f.max_bytes}
+	if *f.timeout > 0 {
+		interpreter.limits.deadline = time.Now().Add(*f.timeout)
+	}
+
+	if *f.trace != "" {
+		interpreter.tracer, err = f.new_Tracer()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return interpreter, nil
+}
+
+func (f *interpreter_flags) new_Tracer() (*Tracer, error) {
+	if *f.trace_format != "text" && *f.trace_format != "json" {
+		return nil, fmt.Errorf("unknown trace format '%s'", *f.trace_format)
+	}
+
+	var writer io.Writer = os.Stderr
+	if *f.trace != "-" {
+		file, err := os.Create(*f.trace)
+		if err != nil {
+			return nil, err
+		}
+		writer = file
+	}
+
+	tracer := new_Tracer(writer, *f.trace_format == "json")
+	tracer.function = *f.trace_function
+	if *f.trace_lines != "" {
+		if err := tracer.set_lines(*f.trace_lines); err != nil {
+			return nil, err
+		}
+	}
+	return tracer, nil
+}
+
+// The exit code for how a script ended, 'err' is what stopped it.
+func exit_code(result InterpreterResult, err *Runtime_Error) int {
+	switch result {
+	case INTERPRETER_RESULT_OK:
+		return 0
+	case INTERPRETER_RESULT_EXIT:
+		return err.status
+	case INTERPETER_RESULT_COMPILE_ERROR:
+		return 65
+	default:
+		return 70
+	}
+}
+
+func run_command(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	interpreter_flags := add_interpreter_flags(flags)
+	profile := flags.String("profile", "", "Write a pprof profile of the script to this file, and a report of where its time went to stderr.")
+	coverage_flags := add_coverage_flags(flags)
+	flags.Parse(args)
+
+	// Ctrl-C stops the script, instead of killing the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	interpreter, err := interpreter_flags.new_Interpreter(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 64
+	}
+
+	file_path := "./test.txt"
+	if flags.NArg() > 0 {
+		file_path = flags.Arg(0)
+	}
+
+	if *profile != "" {
+		interpreter.profiler = new_Profiler()
+	}
+	interpreter.coverage = coverage_flags.new_Coverage()
+
+	register_natives()
+	result := interpreter.run_file(file_path)
+	if interpreter.err != nil && result != INTERPRETER_RESULT_EXIT {
+		fmt.Fprintln(os.Stderr, interpreter.err)
+	}
+
+	if interpreter.profiler != nil && result != INTERPETER_RESULT_COMPILE_ERROR {
+		interpreter.profiler.write_report(os.Stderr)
+		if err := interpreter.profiler.write_pprof(*profile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	if interpreter.coverage != nil && result != INTERPETER_RESULT_COMPILE_ERROR {
+		if err := coverage_flags.write_reports(interpreter.coverage); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	return exit_code(result, interpreter.err)
+}
+
+// Runs the tests of the *_test.tesp files in the directories, or of the files
+// given directly. The exit code is 1 when any of them fails.
+func test_command(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	interpreter_flags := add_interpreter_flags(flags)
+	coverage_flags := add_coverage_flags(flags)
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	interpreter, err := interpreter_flags.new_Interpreter(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 64
+	}
+	interpreter.coverage = coverage_flags.new_Coverage()
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := find_test_files(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 64
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "There are no *_test.tesp files to run.")
+		return 64
+	}
+
+	register_natives()
+	start := time.Now()
+	passed, failed := 0, 0
+	for _, file := range files {
+		file_passed, file_failed := interpreter.run_tests(file, os.Stdout)
+		passed += file_passed
+		failed += file_failed
+	}
+
+	if interpreter.coverage != nil {
+		if err := coverage_flags.write_reports(interpreter.coverage); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed in %v\n", passed, failed, time.Since(start))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Runs a script, stopping at its first line to take debugger commands.
+func debug_command(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	interpreter_flags := add_interpreter_flags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: tesp debug [flags] file")
+		return 64
+	}
+
+	interpreter, err := interpreter_flags.new_Interpreter(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 64
+	}
+	// The debugger reads its commands from the same buffer the script reads
+	// its lines from, so neither reads ahead into the input of the other.
+	interpreter.debugger = new_Console_Debugger(stdin, os.Stdout, flags.Arg(0))
+
+	register_natives()
+	result := interpreter.run_file(flags.Arg(0))
+
+	// Quitting the debugger cancels the script, which isn't an error.
+	if result == INTERPRETER_RESULT_CANCELLED {
+		return 0
+	}
+	if interpreter.err != nil && result != INTERPRETER_RESULT_EXIT {
+		fmt.Fprintln(os.Stderr, interpreter.err)
+	}
+	return exit_code(result, interpreter.err)
+}
+
+// Serves the Debug Adapter Protocol over stdin and stdout, for editors.
+func dap_command(args []string) int {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	interpreter_flags := add_interpreter_flags(flags)
+	flags.Parse(args)
+
+	interpreter, err := interpreter_flags.new_Interpreter(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 64
+	}
+
+	server := new_Dap_Server(interpreter, os.Stdin, os.Stdout)
+	interpreter.debugger = server
+
+	register_natives()
+	if err := server.serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Dumps every token of a file as line:column, kind and lexeme.
+func tokens_command(args []string) int {
+	flags := flag.NewFlagSet("tokens", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: tesp tokens file")
+		return 64
+	}
+
+	lexer, err := new_Lexer_from_file(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 66
+	}
+
+	exit_code := 0
+	for _, token := range lexer.Tokens() {
+		fmt.Printf("%4d:%-3d %-28s %q\n", token.line, token.column, token.t_type, token.lexeme)
+		if token.t_type == TOKEN_ERROR {
+			exit_code = 65
+		}
+	}
+	return exit_code
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Records which instructions ran, and which way each OP_IF_FALSE_JMP went, for
+// every chunk that ran. The reports add them up per line of each file, so a
+// file that is run more than once, like a module, gets the runs together.
+type Coverage struct {
+	chunks  []*Chunk_Coverage
+	current *Chunk_Coverage
+}
+
+type Chunk_Coverage struct {
+	chunk *Chunk
+	hits  []uint64
+
+	// How often each branch went on with the condition being true, and how
+	// often it jumped, by the offset of its OP_IF_FALSE_JMP.
+	branches map[uint32]*[2]uint64
+}
+
+func new_Coverage() *Coverage {
+	return &Coverage{}
+}
+
+// Called with vm.index at the instruction the VM is about to run.
+func (coverage *Coverage) before_instruction(vm *VM) {
+	if coverage.current == nil || coverage.current.chunk != vm.chunk {
+		coverage.current = nil
+		for _, v := range coverage.chunks {
+			if v.chunk == vm.chunk {
+				coverage.current = v
+			}
+		}
+
+		if coverage.current == nil {
+			coverage.current = &Chunk_Coverage{vm.chunk, make([]uint64, len(vm.chunk.code)), make(map[uint32]*[2]uint64)}
+			coverage.chunks = append(coverage.chunks, coverage.current)
+		}
+	}
+
+	coverage.current.hits[vm.index]++
+
+	// The condition is still on the stack, so which way it goes is known now.
+	if vm.chunk.code[vm.index] == OP_IF_FALSE_JMP && len(vm.stack.values) > 0 {
+		branch, ok := coverage.current.branches[vm.index]
+		if !ok {
+			branch = &[2]uint64{}
+			coverage.current.branches[vm.index] = branch
+		}
+
+		condition := vm.stack.values[len(vm.stack.values)-1]
+		if IS_OF_TYPE(&condition, BOOL) && !TO_BOOL_S(&condition) {
+			branch[1]++
+		} else {
+			branch[0]++
+		}
+	}
+}
+
+// The coverage of one file, added up over every chunk it was in.
+type File_Coverage struct {
+	name string
+	path string
+
+	// How often each line that has code ran.
+	lines map[uint32]uint64
+
+	branches []*Branch_Coverage
+}
+
+type Branch_Coverage struct {
+	line uint32
+
+	// Tells branches on the same line apart.
+	block int
+
+	// Whether the OP_IF_FALSE_JMP ran at all, and how often the condition was
+	// true and false.
+	reached bool
+	taken   [2]uint64
+}
+
+func (file *File_Coverage) covered_lines() int {
+	count := 0
+	for _, hits := range file.lines {
+		if hits > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func (file *File_Coverage) covered_branches() int {
+	count := 0
+	for _, branch := range file.branches {
+		for _, taken := range branch.taken {
+			if taken > 0 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func (file *File_Coverage) sorted_lines() []uint32 {
+	var lines []uint32
+	for line := range file.lines {
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
+}
+
+// Gives back the coverage of every file that ran, sorted by path.
+func (coverage *Coverage) files() []*File_Coverage {
+	files := make(map[string]*File_Coverage)
+	type branch_key struct {
+		path  string
+		line  uint32
+		block int
+	}
+	branches := make(map[branch_key]*Branch_Coverage)
+	type file_line struct {
+		path string
+		line uint32
+	}
+
+	for _, v := range coverage.chunks {
+		// A line ran as often as its instruction that ran the most.
+		line_hits := make(map[file_line]uint64)
+		blocks := make(map[file_line]int)
+
+		for offset := 0; offset < len(v.chunk.code); {
+			line := v.chunk.lines[offset]
+			chunk_file := v.chunk.file_at(uint32(offset))
+			next := int(decode_instruction(v.chunk, uint64(offset)).next)
+
+			// Code the compiler adds around the script has no line, and the end
+			// of it is on the line after the last one.
+			if line == 0 || v.chunk.code[offset] == OP_EOF {
+				offset = next
+				continue
+			}
+
+			file, ok := files[chunk_file.path]
+			if !ok {
+				file = &File_Coverage{chunk_file.name, chunk_file.path, make(map[uint32]uint64), nil}
+				files[chunk_file.path] = file
+			}
+
+			key := file_line{chunk_file.path, line}
+			if hits := v.hits[offset]; hits > line_hits[key] {
+				line_hits[key] = hits
+			} else if _, ok := line_hits[key]; !ok {
+				line_hits[key] = 0
+			}
+
+			if v.chunk.code[offset] == OP_IF_FALSE_JMP {
+				block := blocks[key]
+				blocks[key]++
+
+				branch, ok := branches[branch_key{chunk_file.path, line, block}]
+				if !ok {
+					branch = &Branch_Coverage{line: line, block: block}
+					branches[branch_key{chunk_file.path, line, block}] = branch
+					file.branches = append(file.branches, branch)
+				}
+
+				if taken, ok := v.branches[uint32(offset)]; ok {
+					branch.reached = true
+					branch.taken[0] += taken[0]
+					branch.taken[1] += taken[1]
+				}
+			}
+
+			offset = next
+		}
+
+		for key, hits := range line_hits {
+			files[key.path].lines[key.line] += hits
+		}
+	}
+
+	var sorted []*File_Coverage
+	for _, file := range files {
+		sort.Slice(file.branches, func(i, j int) bool {
+			if file.branches[i].line != file.branches[j].line {
+				return file.branches[i].line < file.branches[j].line
+			}
+			return file.branches[i].block < file.branches[j].block
+		})
+		sorted = append(sorted, file)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+	return sorted
+}
+
+func coverage_percent(covered int, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(covered) * 100 / float64(total)
+}
+
+func (coverage *Coverage) write_summary(writer io.Writer) {
+	lines, covered_lines, branches, covered_branches := 0, 0, 0, 0
+
+	for _, file := range coverage.files() {
+		fmt.Fprintf(writer, "%-40s lines %5.1f%% (%d/%d)  branches %5.1f%% (%d/%d)\n", file.name,
+			coverage_percent(file.covered_lines(), len(file.lines)), file.covered_lines(), len(file.lines),
+			coverage_percent(file.covered_branches(), 2*len(file.branches)), file.covered_branches(), 2*len(file.branches))
+
+		lines += len(file.lines)
+		covered_lines += file.covered_lines()
+		branches += 2 * len(file.branches)
+		covered_branches += file.covered_branches()
+	}
+
+	fmt.Fprintf(writer, "%-40s lines %5.1f%% (%d/%d)  branches %5.1f%% (%d/%d)\n", "total",
+		coverage_percent(covered_lines, lines), covered_lines, lines,
+		coverage_percent(covered_branches, branches), covered_branches, branches)
+}
+
+// Writes the coverage as an LCOV tracefile. Branch 0 of a block is the
+// condition being true and branch 1 is it being false.
+func (coverage *Coverage) write_lcov(writer io.Writer) {
+	for _, file := range coverage.files() {
+		fmt.Fprintln(writer, "TN:")
+		fmt.Fprintf(writer, "SF:%s\n", file.path)
+
+		for _, branch := range file.branches {
+			for i, taken := range branch.taken {
+				if branch.reached {
+					fmt.Fprintf(writer, "BRDA:%d,%d,%d,%d\n", branch.line, branch.block, i, taken)
+				} else {
+					fmt.Fprintf(writer, "BRDA:%d,%d,%d,-\n", branch.line, branch.block, i)
+				}
+			}
+		}
+		fmt.Fprintf(writer, "BRF:%d\n", 2*len(file.branches))
+		fmt.Fprintf(writer, "BRH:%d\n", file.covered_branches())
+
+		for _, line := range file.sorted_lines() {
+			fmt.Fprintf(writer, "DA:%d,%d\n", line, file.lines[line])
+		}
+		fmt.Fprintf(writer, "LF:%d\n", len(file.lines))
+		fmt.Fprintf(writer, "LH:%d\n", file.covered_lines())
+		fmt.Fprintln(writer, "end_of_record")
+	}
+}
+
+// Writes a page with the source of every file, where the lines that ran are
+// green, the ones that didn't are red and the ones with a branch that never
+// went one of its ways are yellow.
+func (coverage *Coverage) write_html(writer io.Writer) {
+	fmt.Fprint(writer, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tesp coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; margin: 0; }
+.line { display: block; white-space: pre; }
+.hits { display: inline-block; width: 6em; text-align: right; color: #666; padding-right: 1em; }
+.number { display: inline-block; width: 4em; text-align: right; color: #999; padding-right: 1em; }
+.covered { background: #dfd; }
+.uncovered { background: #fdd; }
+.partial { background: #ffc; }
+</style>
+</head>
+<body>
+`)
+
+	for _, file := range coverage.files() {
+		fmt.Fprintf(writer, "<h2>%s</h2>\n<p>lines %.1f%% (%d/%d), branches %.1f%% (%d/%d)</p>\n<pre>\n",
+			html.EscapeString(file.name),
+			coverage_percent(file.covered_lines(), len(file.lines)), file.covered_lines(), len(file.lines),
+			coverage_percent(file.covered_branches(), 2*len(file.branches)), file.covered_branches(), 2*len(file.branches))
+
+		partial := make(map[uint32]bool)
+		for _, branch := range file.branches {
+			if branch.taken[0] == 0 || branch.taken[1] == 0 {
+				partial[branch.line] = true
+			}
+		}
+
+		var source []string
+		if contents, err := os.ReadFile(file.path); err == nil {
+			source = strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+		}
+
+		for i, text := range source {
+			line := uint32(i + 1)
+			class, hits := "", ""
+			if count, ok := file.lines[line]; ok {
+				hits = fmt.Sprint(count)
+				switch {
+				case count == 0:
+					class = "uncovered"
+				case partial[line]:
+					class = "partial"
+				default:
+					class = "covered"
+				}
+			}
+
+			fmt.Fprintf(writer, "<span class=\"line %s\"><span class=\"number\">%d</span><span class=\"hits\">%s</span>%s</span>",
+				class, line, hits, html.EscapeString(strings.TrimRight(text, "\r")))
+		}
+		fmt.Fprint(writer, "</pre>\n")
+	}
+
+	fmt.Fprint(writer, "</body>\n</html>\n")
+}
+
+// The flags of the commands that can report coverage.
+type coverage_flags struct {
+	cover *bool
+	lcov  *string
+	html  *string
+}
+
+func add_coverage_flags(flags *flag.FlagSet) *coverage_flags {
+	return &coverage_flags{
+		flags.Bool("cover", false, "Print how much of each file ran, by line and by branch."),
+		flags.String("coverprofile", "", "Write the coverage as an LCOV file."),
+		flags.String("coverhtml", "", "Write the source annotated with its coverage as an HTML file."),
+	}
+}
+
+// Gives back nil when no coverage was asked for.
+func (f *coverage_flags) new_Coverage() *Coverage {
+	if !*f.cover && *f.lcov == "" && *f.html == "" {
+		return nil
+	}
+	return new_Coverage()
+}
+
+func (f *coverage_flags) write_reports(coverage *Coverage) error {
+	if *f.cover {
+		coverage.write_summary(os.Stderr)
+	}
+
+	reports := []struct {
+		path  string
+		write func(io.Writer)
+	}{
+		{*f.lcov, coverage.write_lcov},
+		{*f.html, coverage.write_html},
+	}
+
+	for _, report := range reports {
+		if report.path == "" {
+			continue
+		}
+
+		file, err := os.Create(report.path)
+		if err != nil {
+			return err
+		}
+		report.write(file)
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCoverageLCOV(t *testing.T) {
+	register_natives_once.Do(register_natives)
+
+	program := `(func sign [x int] int (if (< x 0)
+    (return -1)
+    (return 1)))
+(println (sign 5))
+`
+
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	interpreter.stdout = &bytes.Buffer{}
+	interpreter.coverage = new_Coverage()
+	path := write_program(t, program)
+	if result := interpreter.run_file(path); result != INTERPRETER_RESULT_OK {
+		t.Fatalf("The program failed with %v.", interpreter.err)
+	}
+
+	var lcov bytes.Buffer
+	interpreter.coverage.write_lcov(&lcov)
+
+	// The condition was never true, so line 2 didn't run and the first branch
+	// wasn't taken.
+	want := "TN:\nSF:" + path + "\n" +
+		"BRDA:1,0,0,0\nBRDA:1,0,1,1\nBRF:2\nBRH:1\n" +
+		"DA:1,1\nDA:2,0\nDA:3,1\nDA:4,1\nLF:4\nLH:3\n" +
+		"end_of_record\n"
+	if lcov.String() != want {
+		t.Errorf("The LCOV file is:\n%s\nexpected:\n%s", lcov.String(), want)
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Debug Adapter Protocol server, so editors can debug scripts. Requests are
+// handled on one goroutine while the script runs on another, which blocks in
+// Before_Instruction whenever it is stopped.
+
+type Dap_Request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type Dap_Server struct {
+	Stepper
+	interpreter *Interpreter
+	reader      *bufio.Reader
+
+	write_lock sync.Mutex
+	writer     io.Writer
+	seq        int
+
+	// Everything below is shared with the goroutine running the script.
+	lock        sync.Mutex
+	breakpoints map[Breakpoint]bool
+	program     string
+	stop_entry  bool
+	configured  bool
+	started     bool
+	quitting    bool
+	stopped     bool
+	resumed     chan struct{}
+
+	// The VM while the script is stopped, nil while it runs.
+	vm *VM
+}
+
+// The protocol goes over 'input' and 'output', so what the script prints is
+// sent as output events and it has nothing to read.
+func new_Dap_Server(interpreter *Interpreter, input io.Reader, output io.Writer) *Dap_Server {
+	server := &Dap_Server{
+		interpreter: interpreter,
+		reader:      bufio.NewReader(input),
+		writer:      output,
+		breakpoints: make(map[Breakpoint]bool),
+		resumed:     make(chan struct{}),
+	}
+
+	interpreter.stdout = Dap_Output{server, "stdout"}
+	interpreter.stderr = Dap_Output{server, "stderr"}
+	interpreter.stdin = strings.NewReader("")
+	return server
+}
+
+func (server *Dap_Server) read_message() (*Dap_Request, error) {
+	length := -1
+	for {
+		line, err := server.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if value, found := strings.CutPrefix(line, "Content-Length:"); found {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("bad Content-Length: %s", value)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(server.reader, body); err != nil {
+		return nil, err
+	}
+
+	request := &Dap_Request{}
+	if err := json.Unmarshal(body, request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (server *Dap_Server) send(message map[string]interface{}) {
+	server.write_lock.Lock()
+	defer server.write_lock.Unlock()
+
+	server.seq++
+	message["seq"] = server.seq
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Fprintf(server.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (server *Dap_Server) respond(request *Dap_Request, body interface{}) {
+	message := map[string]interface{}{
+		"type":        "response",
+		"request_seq": request.Seq,
+		"success":     true,
+		"command":     request.Command,
+	}
+	if body != nil {
+		message["body"] = body
+	}
+	server.send(message)
+}
+
+func (server *Dap_Server) respond_error(request *Dap_Request, msg string) {
+	server.send(map[string]interface{}{
+		"type":        "response",
+		"request_seq": request.Seq,
+		"success":     false,
+		"command":     request.Command,
+		"message":     msg,
+	})
+}
+
+func (server *Dap_Server) event(name string, body interface{}) {
+	message := map[string]interface{}{
+		"type":  "event",
+		"event": name,
+	}
+	if body != nil {
+		message["body"] = body
+	}
+	server.send(message)
+}
+
+func (server *Dap_Server) output(category string, text string) {
+	server.event("output", map[string]interface{}{"category": category, "output": text})
+}
+
+// Sends what gets written to it as output events of the category.
+type Dap_Output struct {
+	server   *Dap_Server
+	category string
+}
+
+func (output Dap_Output) Write(p []byte) (int, error) {
+	output.server.output(output.category, string(p))
+	return len(p), nil
+}
+
+// Handles requests until the editor disconnects.
+func (server *Dap_Server) serve() error {
+	for {
+		request, err := server.read_message()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if request.Type != "request" {
+			continue
+		}
+		if !server.handle(request) {
+			return nil
+		}
+	}
+}
+
+// Gives back false once the session is over.
+func (server *Dap_Server) handle(request *Dap_Request) bool {
+	switch request.Command {
+	case "initialize":
+		server.respond(request, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+		})
+		server.event("initialized", nil)
+
+	case "launch":
+		var arguments struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		json.Unmarshal(request.Arguments, &arguments)
+		if arguments.Program == "" {
+			server.respond_error(request, "Expected the script to debug as 'program'.")
+			break
+		}
+
+		server.lock.Lock()
+		server.program = arguments.Program
+		server.stop_entry = arguments.StopOnEntry
+		server.lock.Unlock()
+
+		server.respond(request, nil)
+		server.start()
+
+	case "setBreakpoints":
+		server.set_breakpoints(request)
+
+	case "configurationDone":
+		server.lock.Lock()
+		server.configured = true
+		server.lock.Unlock()
+
+		server.respond(request, nil)
+		server.start()
+
+	case "threads":
+		server.respond(request, map[string]interface{}{
+			"threads": []interface{}{map[string]interface{}{"id": 1, "name": "main"}},
+		})
+
+	case "stackTrace":
+		server.stack_trace(request)
+
+	case "scopes":
+		server.scopes(request)
+
+	case "variables":
+		server.variables(request)
+
+	case "continue":
+		server.respond(request, map[string]interface{}{"allThreadsContinued": true})
+		server.resume_vm(STEP_CONTINUE)
+
+	case "next":
+		server.respond(request, nil)
+		server.resume_vm(STEP_OVER)
+
+	case "stepIn":
+		server.respond(request, nil)
+		server.resume_vm(STEP_INTO)
+
+	case "stepOut":
+		server.respond(request, nil)
+		server.resume_vm(STEP_OUT)
+
+	case "disconnect", "terminate":
+		server.lock.Lock()
+		server.quitting = true
+		server.lock.Unlock()
+
+		server.respond(request, nil)
+		server.resume_vm(STEP_CONTINUE)
+		return request.Command != "disconnect"
+
+	default:
+		server.respond_error(request, fmt.Sprintf("'%s' isn't supported.", request.Command))
+	}
+
+	return true
+}
+
+func (server *Dap_Server) set_breakpoints(request *Dap_Request) {
+	var arguments struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line uint32 `json:"line"`
+		} `json:"breakpoints"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	path, err := filepath.Abs(arguments.Source.Path)
+	if err != nil {
+		path = arguments.Source.Path
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	// Each request has all the breakpoints of the file.
+	for breakpoint := range server.breakpoints {
+		if breakpoint.file == path {
+			delete(server.breakpoints, breakpoint)
+		}
+	}
+
+	breakpoints := []interface{}{}
+	for _, v := range arguments.Breakpoints {
+		server.breakpoints[Breakpoint{path, v.Line}] = true
+		breakpoints = append(breakpoints, map[string]interface{}{"verified": true, "line": v.Line})
+	}
+
+	server.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+}
+
+// The script starts once it has been launched and the editor is done sending
+// its breakpoints.
+func (server *Dap_Server) start() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if server.started || !server.configured || server.program == "" {
+		return
+	}
+	server.started = true
+
+	if server.stop_entry {
+		server.Stepper.mode = STEP_INTO
+	}
+
+	go func() {
+		result := server.interpreter.run_file(server.program)
+		if server.interpreter.err != nil && result != INTERPRETER_RESULT_CANCELLED && result != INTERPRETER_RESULT_EXIT {
+			server.output("stderr", server.interpreter.err.Error()+"\n")
+		}
+
+		server.event("exited", map[string]interface{}{"exitCode": exit_code(result, server.interpreter.err)})
+		server.event("terminated", nil)
+	}()
+}
+
+func (server *Dap_Server) Before_Instruction(vm *VM) {
+	server.lock.Lock()
+	if server.quitting {
+		server.lock.Unlock()
+		stop_execution(INTERPRETER_RESULT_CANCELLED, "The debugger disconnected.")
+	}
+
+	if !server.new_line(vm) {
+		server.lock.Unlock()
+		return
+	}
+
+	reason := ""
+	if server.breakpoints[Breakpoint{server.file.path, server.line}] {
+		reason = "breakpoint"
+	} else if server.step_done() {
+		reason = "step"
+		if !server.stopped && server.stop_entry {
+			reason = "entry"
+		}
+	}
+
+	if reason == "" {
+		server.lock.Unlock()
+		return
+	}
+
+	server.vm = vm
+	server.stopped = true
+	server.lock.Unlock()
+
+	server.event("stopped", map[string]interface{}{"reason": reason, "threadId": 1, "allThreadsStopped": true})
+	<-server.resumed
+}
+
+// Lets the script carry on, if it's stopped.
+func (server *Dap_Server) resume_vm(mode Step_Mode) {
+	server.lock.Lock()
+	stopped := server.vm != nil
+	if stopped {
+		server.resume(mode)
+		server.vm = nil
+	}
+	server.lock.Unlock()
+
+	if stopped {
+		server.resumed <- struct{}{}
+	}
+}
+
+func (server *Dap_Server) stack_trace(request *Dap_Request) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	frames := []interface{}{}
+	if server.vm != nil {
+		for i, frame := range server.vm.stack_trace(server.vm.index) {
+			frames = append(frames, map[string]interface{}{
+				"id":     i,
+				"name":   frame.function,
+				"source": map[string]interface{}{"name": frame.file, "path": frame.path},
+				"line":   frame.line,
+				"column": 1,
+			})
+		}
+	}
+
+	server.respond(request, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+// Every scope of 'env' is its own DAP scope, the variables reference of one is
+// its scope number plus one, as 0 means there aren't any variables.
+func (server *Dap_Server) scopes(request *Dap_Request) {
+	var arguments struct {
+		FrameId int `json:"frameId"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	scopes := []interface{}{}
+	if server.vm != nil {
+		for _, scope := range server.vm.debug_scopes() {
+			if scope.frame != arguments.FrameId || len(scope.entries) == 0 {
+				continue
+			}
+
+			scopes = append(scopes, map[string]interface{}{
+				"name":               fmt.Sprintf("Scope %d", scope.scope),
+				"variablesReference": int(scope.scope) + 1,
+				"namedVariables":     len(scope.entries),
+				"expensive":          false,
+			})
+		}
+	}
+
+	server.respond(request, map[string]interface{}{"scopes": scopes})
+}
+
+func (server *Dap_Server) variables(request *Dap_Request) {
+	var arguments struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	variables := []interface{}{}
+	if server.vm != nil {
+		for _, scope := range server.vm.debug_scopes() {
+			if int(scope.scope)+1 != arguments.VariablesReference {
+				continue
+			}
+
+			for _, entry := range scope.entries {
+				variables = append(variables, map[string]interface{}{
+					"name":               entry.name,
+					"value":              TO_STRING_S(&entry.value),
+					"type":               ValueTypes_to_string(entry.vtype),
+					"variablesReference": 0,
+				})
+			}
+		}
+	}
+
+	server.respond(request, map[string]interface{}{"variables": variables})
+}
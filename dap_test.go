@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The editor side of a session with a Dap_Server.
+type dap_client struct {
+	t        *testing.T
+	input    *io.PipeWriter
+	messages chan map[string]interface{}
+	seq      int
+
+	// What the script printed, from the output events.
+	output strings.Builder
+}
+
+func new_dap_client(t *testing.T, server_output io.Reader, server_input *io.PipeWriter) *dap_client {
+	client := &dap_client{t: t, input: server_input, messages: make(chan map[string]interface{}, 100)}
+
+	go func() {
+		defer close(client.messages)
+		reader := bufio.NewReader(server_output)
+		for {
+			header, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "Content-Length:")))
+			if err != nil {
+				return
+			}
+			reader.ReadString('\n')
+
+			body := make([]byte, length)
+			if _, err := io.ReadFull(reader, body); err != nil {
+				return
+			}
+			message := make(map[string]interface{})
+			json.Unmarshal(body, &message)
+			client.messages <- message
+		}
+	}()
+	return client
+}
+
+func (client *dap_client) request(command string, arguments interface{}) {
+	client.seq++
+	body, _ := json.Marshal(map[string]interface{}{"seq": client.seq, "type": "request", "command": command, "arguments": arguments})
+	fmt.Fprintf(client.input, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// Waits for the response to the command or the event, skipping the messages
+// before it.
+func (client *dap_client) expect(kind string, name string) map[string]interface{} {
+	client.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message, ok := <-client.messages:
+			if !ok {
+				client.t.Fatalf("The server stopped before sending the %s %s.", name, kind)
+			}
+			if message["event"] == "output" {
+				body := message["body"].(map[string]interface{})
+				client.output.WriteString(body["output"].(string))
+			}
+			if message["type"] != kind || (message["command"] != name && message["event"] != name) {
+				continue
+			}
+			if kind == "response" && message["success"] != true {
+				client.t.Fatalf("The %s request failed: %v", name, message["message"])
+			}
+			return message
+		case <-timeout:
+			client.t.Fatalf("Timed out waiting for the %s %s.", name, kind)
+		}
+	}
+}
+
+func TestDapSession(t *testing.T) {
+	register_natives_once.Do(register_natives)
+	path := write_program(t, debugged_program)
+
+	server_input, client_output := io.Pipe()
+	client_input, server_output := io.Pipe()
+	server := new_Dap_Server(new_Interpreter(PERMISSION_PURE), server_input, server_output)
+	server.interpreter.debugger = server
+	env = new_Environment()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.serve()
+		server_output.Close()
+	}()
+	client := new_dap_client(t, client_input, client_output)
+
+	client.request("initialize", map[string]interface{}{"adapterID": "tesp"})
+	client.expect("response", "initialize")
+	client.expect("event", "initialized")
+
+	client.request("launch", map[string]interface{}{"program": path})
+	client.expect("response", "launch")
+	client.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 3}},
+	})
+	client.expect("response", "setBreakpoints")
+	client.request("configurationDone", nil)
+	client.expect("response", "configurationDone")
+
+	stopped := client.expect("event", "stopped")
+	if reason := stopped["body"].(map[string]interface{})["reason"]; reason != "breakpoint" {
+		t.Errorf("Stopped for a %v, expected the breakpoint.", reason)
+	}
+
+	client.request("stackTrace", map[string]interface{}{"threadId": 1})
+	frames := client.expect("response", "stackTrace")["body"].(map[string]interface{})["stackFrames"].([]interface{})
+	var trace []string
+	for _, v := range frames {
+		frame := v.(map[string]interface{})
+		trace = append(trace, fmt.Sprintf("%v:%v", frame["name"], frame["line"]))
+	}
+	if got := strings.Join(trace, " "); got != "twice:3 script:5" {
+		t.Errorf("The stack trace is %s, expected twice:3 script:5.", got)
+	}
+
+	client.request("next", map[string]interface{}{"threadId": 1})
+	client.expect("response", "next")
+	client.expect("event", "stopped")
+
+	client.request("continue", map[string]interface{}{"threadId": 1})
+	client.expect("response", "continue")
+	exited := client.expect("event", "exited")
+	if code := exited["body"].(map[string]interface{})["exitCode"]; code != 0.0 {
+		t.Errorf("The script exited with %v.", code)
+	}
+	client.expect("event", "terminated")
+	if client.output.String() != "2\n" {
+		t.Errorf("The script printed %q, expected \"2\\n\".", client.output.String())
+	}
+
+	client.request("disconnect", nil)
+	client.expect("response", "disconnect")
+	if err := <-served; err != nil {
+		t.Errorf("The server stopped with %v.", err)
+	}
+	client_output.Close()
+}
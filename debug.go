@@ -3,65 +3,77 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
+	"strconv"
 )
 
-func simple_instruction(name string, offset uint64) uint64 {
-	fmt.Println(name)
-	return offset + 1
+// What an instruction decodes to. 'operands' are the plain values of its
+// operands and 'text' is how the disassembler shows them.
+type Decoded_Instruction struct {
+	name     string
+	operands []string
+	text     string
+	next     uint64
 }
 
-func constant_instruction(name string, chunk *Chunk, offset uint64) uint64 {
-	fmt.Print(name, "   '")
+func simple_instruction(name string, offset uint64) Decoded_Instruction {
+	return Decoded_Instruction{name, nil, "", offset + 1}
+}
 
-	var index uint16 = binary.BigEndian.Uint16([]byte{chunk.code[offset+1], chunk.code[offset+2]})
+func constant_instruction(name string, chunk *Chunk, offset uint64) Decoded_Instruction {
+	var index uint32 = uint32(binary.BigEndian.Uint16([]byte{chunk.code[offset+1], chunk.code[offset+2]}))
+	next := offset + 3
+	if chunk.code[offset] == OP_PUSH_LONG {
+		index = index<<8 | uint32(chunk.code[offset+3])
+		next++
+	}
 
 	value := chunk.constants.values[index]
+	value_text := value.String()
+	type_text := ValueTypes_to_string(value.value_type)
 
-	print_Value(value)
-	fmt.Print("'  ")
-	fmt.Println(ValueTypes_to_string(*&value.value_type))
-	return offset + 3
+	return Decoded_Instruction{name, []string{value_text, type_text}, "'" + value_text + "'  " + type_text, next}
 }
 
-func jmp_instruction(name string, chunk *Chunk, offset uint64) uint64 {
-	fmt.Print(name, "   '")
-
+func jmp_instruction(name string, chunk *Chunk, offset uint64) Decoded_Instruction {
 	index := binary.BigEndian.Uint32([]byte{chunk.code[offset+1], chunk.code[offset+2], chunk.code[offset+3], chunk.code[offset+4]})
 
-	fmt.Print(index)
-	fmt.Println("'")
-	return offset + 5
+	index_text := strconv.FormatUint(uint64(index), 10)
+	return Decoded_Instruction{name, []string{index_text}, "'" + index_text + "'", offset + 5}
 }
 
-func store_instruction(name string, load_op bool, chunk *Chunk, offset uint64) uint64 {
-	fmt.Print(name, "   '")
-
+func store_instruction(name string, load_op bool, chunk *Chunk, offset uint64) Decoded_Instruction {
 	offset++
-	original_offset := offset
-	var count uint32
-
+	start := offset
 	for chunk.code[offset] != OP_END_QUOTE {
 		offset++
-		count++
 	}
+	variable := string(chunk.code[start:offset])
+	offset++
+
+	if !load_op {
+		type_text := ValueTypes_to_string(ValueTypes(chunk.code[offset]))
+		return Decoded_Instruction{name, []string{variable, type_text}, "'" + variable + "'  " + type_text, offset + 1}
+	} else {
+		return Decoded_Instruction{name, []string{variable}, "'" + variable + "'", offset}
+	}
+}
 
-	offset = original_offset
+func type_instruction(name string, chunk *Chunk, offset uint64) Decoded_Instruction {
+	type_text := ValueTypes_to_string(ValueTypes(chunk.code[offset+1]))
+	return Decoded_Instruction{name, []string{type_text}, type_text, offset + 2}
+}
 
-	name_bytes := make([]byte, count, count)
+func call_instruction(name string, chunk *Chunk, offset uint64) Decoded_Instruction {
+	offset++
+	start := offset
 	for chunk.code[offset] != OP_END_QUOTE {
-		name_bytes[offset-original_offset] = chunk.code[offset]
 		offset++
 	}
-	fmt.Print(string(name_bytes))
+	function := string(chunk.code[start:offset])
 	offset++
-	fmt.Print("'  ")
-	if !load_op {
-		fmt.Println(ValueTypes_to_string(ValueTypes(chunk.code[offset])))
-		return offset + 1
-	} else {
-		fmt.Println()
-		return offset
-	}
+
+	arity := strconv.Itoa(int(chunk.code[offset]))
+	return Decoded_Instruction{name, []string{function, arity}, "'" + function + "'  " + arity, offset + 1}
 }
 
 func disassemble_chunk(chunk *Chunk, name string) {
@@ -81,6 +93,16 @@ func disassemble_instruction(chunk *Chunk, offset uint64) uint64 {
 		fmt.Printf("%4d ", chunk.lines[offset])
 	}
 
+	instruction := decode_instruction(chunk, offset)
+	if instruction.text == "" {
+		fmt.Println(instruction.name)
+	} else {
+		fmt.Println(instruction.name + "   " + instruction.text)
+	}
+	return instruction.next
+}
+
+func decode_instruction(chunk *Chunk, offset uint64) Decoded_Instruction {
 	instruction := chunk.code[offset]
 	switch instruction {
 	case OP_START_SCOPE:
@@ -89,6 +111,12 @@ func disassemble_instruction(chunk *Chunk, offset uint64) uint64 {
 	case OP_END_SCOPE:
 		return simple_instruction("OP_END_SCOPE", offset)
 
+	case OP_START_MODULE:
+		return store_instruction("OP_START_MODULE", true, chunk, offset)
+
+	case OP_END_MODULE:
+		return store_instruction("OP_END_MODULE", true, chunk, offset)
+
 	case OP_LOAD:
 		return store_instruction("OP_LOAD", true, chunk, offset)
 
@@ -96,7 +124,7 @@ func disassemble_instruction(chunk *Chunk, offset uint64) uint64 {
 		return store_instruction("OP_ASSIGN", true, chunk, offset)
 
 	case OP_CALL_FUNC:
-		return store_instruction("OP_CALL_FUNC", true, chunk, offset)
+		return call_instruction("OP_CALL_FUNC", chunk, offset)
 
 	case OP_PRINT:
 		return simple_instruction("OP_PRINT", offset)
@@ -104,9 +132,21 @@ func disassemble_instruction(chunk *Chunk, offset uint64) uint64 {
 	case OP_PRINTLN:
 		return simple_instruction("OP_PRINTLN", offset)
 
+	case OP_EPRINTLN:
+		return simple_instruction("OP_EPRINTLN", offset)
+
+	case OP_READ_LINE:
+		return simple_instruction("OP_READ_LINE", offset)
+
 	case OP_NEGATE:
 		return simple_instruction("OP_NEGATE", offset)
 
+	case OP_IS_TYPE:
+		return type_instruction("OP_IS_TYPE", chunk, offset)
+
+	case OP_CONVERT:
+		return type_instruction("OP_CONVERT", chunk, offset)
+
 	case OP_POP:
 		return simple_instruction("OP_POP", offset)
 
@@ -116,6 +156,15 @@ func disassemble_instruction(chunk *Chunk, offset uint64) uint64 {
 	case OP_IF_FALSE_JMP:
 		return jmp_instruction("OP_IF_FALSE_JMP", chunk, offset)
 
+	case OP_TRY:
+		return jmp_instruction("OP_TRY", chunk, offset)
+
+	case OP_END_TRY:
+		return simple_instruction("OP_END_TRY", offset)
+
+	case OP_THROW:
+		return simple_instruction("OP_THROW", offset)
+
 	case OP_CMP_GREATER:
 		return simple_instruction("OP_CMP_GREATER", offset)
 
@@ -152,12 +201,39 @@ func disassemble_instruction(chunk *Chunk, offset uint64) uint64 {
 	case OP_MUL:
 		return simple_instruction("OP_MUL", offset)
 
+	case OP_MOD:
+		return simple_instruction("OP_MOD", offset)
+
+	case OP_FLOOR_DIV:
+		return simple_instruction("OP_FLOOR_DIV", offset)
+
+	case OP_POW:
+		return simple_instruction("OP_POW", offset)
+
+	case OP_BIT_AND:
+		return simple_instruction("OP_BIT_AND", offset)
+
+	case OP_BIT_OR:
+		return simple_instruction("OP_BIT_OR", offset)
+
+	case OP_BIT_XOR:
+		return simple_instruction("OP_BIT_XOR", offset)
+
+	case OP_SHIFT_LEFT:
+		return simple_instruction("OP_SHIFT_LEFT", offset)
+
+	case OP_SHIFT_RIGHT:
+		return simple_instruction("OP_SHIFT_RIGHT", offset)
+
 	case OP_STORE:
 		return store_instruction("OP_STORE", false, chunk, offset)
 
 	case OP_PUSH:
 		return constant_instruction("OP_PUSH", chunk, offset)
 
+	case OP_PUSH_LONG:
+		return constant_instruction("OP_PUSH_LONG", chunk, offset)
+
 	case OP_RETURN:
 		return simple_instruction("OP_RETURN", offset)
 
@@ -165,7 +241,6 @@ func disassemble_instruction(chunk *Chunk, offset uint64) uint64 {
 		return simple_instruction("OP_EOF", offset)
 
 	default:
-		fmt.Println("Unknown opcode ", instruction)
-		return offset + 1
+		return Decoded_Instruction{"Unknown opcode " + strconv.Itoa(int(instruction)), nil, "", offset + 1}
 	}
 }
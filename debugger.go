@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Hooks for tools that follow a script while it runs. The VM calls
+// Before_Instruction with vm.index at the instruction it is about to run, so
+// the VM can be looked at, and paused by not returning yet.
+type Debugger interface {
+	Before_Instruction(vm *VM)
+}
+
+// The variables of one scope and the function it belongs to, which is "script"
+// outside of functions.
+type Debug_Scope struct {
+	scope    uint8
+	function string
+	entries  []Entry
+
+	// Which frame of the stack trace the scope is in, 0 is the innermost one.
+	frame int
+}
+
+// Gives back the scopes that are alive, the innermost one first.
+func (vm *VM) debug_scopes() []Debug_Scope {
+	var scopes []Debug_Scope
+
+	for scope := int(env.currentScope); scope >= 0; scope-- {
+		function := "script"
+		frame := len(vm.function_starting_scope)
+		for i := len(vm.function_starting_scope) - 1; i >= 0; i-- {
+			if uint8(scope) >= vm.function_starting_scope[i] {
+				function = vm.function_names[i]
+				frame = len(vm.function_starting_scope) - 1 - i
+				break
+			}
+		}
+
+		var entries []Entry
+		for _, v := range env.Entries {
+			if v.scope == uint8(scope) {
+				entries = append(entries, v)
+			}
+		}
+		scopes = append(scopes, Debug_Scope{uint8(scope), function, entries, frame})
+	}
+
+	return scopes
+}
+
+// Gives back true if any instruction of the file is on the line.
+func (chunk *Chunk) has_code_on_line(file string, line uint32) bool {
+	for offset, v := range chunk.lines {
+		if v == line && chunk.file_at(uint32(offset)).name == file {
+			return true
+		}
+	}
+	return false
+}
+
+type Step_Mode byte
+
+const (
+	STEP_CONTINUE Step_Mode = iota
+	STEP_INTO
+	STEP_OVER
+	STEP_OUT
+)
+
+// Keeps track of the line the VM is on, to work out where stepping stops.
+// Both debuggers use it.
+type Stepper struct {
+	// How to carry on after a pause, and how deep the calls were when it paused.
+	mode  Step_Mode
+	depth int
+
+	// Where the VM was before the current instruction, so it only stops at the
+	// first instruction of a line.
+	file     Chunk_File
+	line     uint32
+	vm_depth int
+	index    uint32
+}
+
+// Gives back true when the VM gets to the first instruction of a line.
+func (stepper *Stepper) new_line(vm *VM) bool {
+	file := vm.chunk.file_at(vm.index)
+	line := vm.chunk.lines[vm.index]
+	depth := len(vm.function_jump_back)
+
+	// Code the compiler adds around the script, like its scope, has no line.
+	if line == 0 {
+		return false
+	}
+
+	// Going back to an earlier instruction on the same line, like at the start
+	// of a loop, counts as getting to the line again.
+	same_line := file.name == stepper.file.name && line == stepper.line && depth == stepper.vm_depth
+	if same_line && vm.index > stepper.index {
+		stepper.index = vm.index
+		return false
+	}
+
+	stepper.file, stepper.line, stepper.vm_depth, stepper.index = file, line, depth, vm.index
+	return true
+}
+
+// Whether stepping stops on the new line, breakpoints aside.
+func (stepper *Stepper) step_done() bool {
+	switch stepper.mode {
+	case STEP_INTO:
+		return true
+	case STEP_OVER:
+		return stepper.vm_depth <= stepper.depth
+	case STEP_OUT:
+		return stepper.vm_depth < stepper.depth
+	}
+	return false
+}
+
+func (stepper *Stepper) resume(mode Step_Mode) {
+	stepper.mode = mode
+	stepper.depth = stepper.vm_depth
+}
+
+type Breakpoint struct {
+	file string
+	line uint32
+}
+
+// The debugger of 'tesp debug', which reads commands from a terminal.
+type Console_Debugger struct {
+	Stepper
+	input       *bufio.Reader
+	output      io.Writer
+	main_file   string
+	breakpoints map[Breakpoint]bool
+	last_line   string
+
+	sources map[string][]string
+}
+
+func new_Console_Debugger(input *bufio.Reader, output io.Writer, main_file string) *Console_Debugger {
+	return &Console_Debugger{
+		Stepper:     Stepper{mode: STEP_INTO},
+		input:       input,
+		output:      output,
+		main_file:   main_file,
+		breakpoints: make(map[Breakpoint]bool),
+		sources:     make(map[string][]string),
+	}
+}
+
+func (debugger *Console_Debugger) Before_Instruction(vm *VM) {
+	if !debugger.new_line(vm) {
+		return
+	}
+
+	if debugger.step_done() || debugger.breakpoints[Breakpoint{debugger.file.name, debugger.line}] {
+		debugger.pause(vm, debugger.file)
+	}
+}
+
+func (debugger *Console_Debugger) pause(vm *VM, file Chunk_File) {
+	fmt.Fprintf(debugger.output, "%s:%d", file.name, debugger.line)
+	if source := debugger.source_line(file.path, debugger.line); source != "" {
+		fmt.Fprintf(debugger.output, "  %s", strings.TrimSpace(source))
+	}
+	fmt.Fprintln(debugger.output)
+
+	for {
+		fmt.Fprint(debugger.output, "(tesp) ")
+		text, err := debugger.input.ReadString('\n')
+		if err != nil && text == "" {
+			// Without any more input the script just runs to the end.
+			fmt.Fprintln(debugger.output)
+			debugger.breakpoints = make(map[Breakpoint]bool)
+			debugger.resume(STEP_CONTINUE)
+			return
+		}
+
+		// An empty line repeats the last command, like stepping again.
+		text = strings.TrimSpace(text)
+		if text == "" {
+			text = debugger.last_line
+		}
+		debugger.last_line = text
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "c", "continue":
+			debugger.resume(STEP_CONTINUE)
+			return
+		case "s", "step":
+			debugger.resume(STEP_INTO)
+			return
+		case "n", "next":
+			debugger.resume(STEP_OVER)
+			return
+		case "o", "out", "finish":
+			debugger.resume(STEP_OUT)
+			return
+		case "b", "break":
+			debugger.set_breakpoint(vm, fields[1:], true)
+		case "d", "delete":
+			debugger.set_breakpoint(vm, fields[1:], false)
+		case "v", "vars":
+			debugger.print_scopes(vm)
+		case "bt", "backtrace":
+			for _, frame := range vm.stack_trace(vm.index) {
+				fmt.Fprintf(debugger.output, "    at %s (%s:%d)\n", frame.function, frame.file, frame.line)
+			}
+		case "l", "list":
+			debugger.list(file.path, debugger.line)
+		case "q", "quit":
+			stop_execution(INTERPRETER_RESULT_CANCELLED, "Quit the debugger.")
+		case "h", "help":
+			fmt.Fprintln(debugger.output, "c(ontinue)  s(tep) into calls  n(ext) line  o(ut) of the function")
+			fmt.Fprintln(debugger.output, "b(reak) [file:]line  d(elete) [file:]line  v(ars)  bt (backtrace)  l(ist)  q(uit)")
+		default:
+			fmt.Fprintf(debugger.output, "Unknown command '%s', 'help' lists them.\n", fields[0])
+		}
+	}
+}
+
+func (debugger *Console_Debugger) set_breakpoint(vm *VM, args []string, set bool) {
+	if len(args) != 1 {
+		fmt.Fprintln(debugger.output, "Expected a line, or file:line.")
+		return
+	}
+
+	file := debugger.main_file
+	line_text := args[0]
+	if i := strings.LastIndex(line_text, ":"); i >= 0 {
+		file, line_text = line_text[:i], line_text[i+1:]
+	}
+
+	line, err := strconv.ParseUint(line_text, 10, 32)
+	if err != nil {
+		fmt.Fprintf(debugger.output, "'%s' isn't a line.\n", line_text)
+		return
+	}
+
+	breakpoint := Breakpoint{file, uint32(line)}
+	if !set {
+		delete(debugger.breakpoints, breakpoint)
+		return
+	}
+
+	if !vm.chunk.has_code_on_line(file, uint32(line)) {
+		fmt.Fprintf(debugger.output, "There is no code on %s:%d, so it will never be hit.\n", file, line)
+	}
+	debugger.breakpoints[breakpoint] = true
+}
+
+func (debugger *Console_Debugger) print_scopes(vm *VM) {
+	for _, scope := range vm.debug_scopes() {
+		if len(scope.entries) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(debugger.output, "Scope %d (%s):\n", scope.scope, scope.function)
+		for _, entry := range scope.entries {
+			fmt.Fprintf(debugger.output, "    %s %s = %s\n", entry.name, ValueTypes_to_string(entry.vtype), TO_STRING_S(&entry.value))
+		}
+	}
+}
+
+func (debugger *Console_Debugger) list(path string, line uint32) {
+	for i := int(line) - 3; i <= int(line)+3; i++ {
+		if i < 1 {
+			continue
+		}
+
+		source := debugger.source_line(path, uint32(i))
+		if source == "" && i > int(line) {
+			break
+		}
+
+		marker := " "
+		if uint32(i) == line {
+			marker = ">"
+		}
+		fmt.Fprintf(debugger.output, "%s %4d  %s\n", marker, i, source)
+	}
+}
+
+func (debugger *Console_Debugger) source_line(path string, line uint32) string {
+	lines, ok := debugger.sources[path]
+	if !ok {
+		contents, err := os.ReadFile(path)
+		if err == nil {
+			lines = strings.Split(string(contents), "\n")
+		}
+		debugger.sources[path] = lines
+	}
+
+	if line == 0 || int(line) > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
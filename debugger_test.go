@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const debugged_program = `(func twice [x int] int (
+    (var y (* x 2))
+    (return y)))
+(var a 1)
+(var b (twice a))
+(println b)
+`
+
+// Runs the program under the console debugger with the commands as its input,
+// giving back the lines it paused on and everything it printed.
+func run_debugged(t *testing.T, commands string) ([]string, string) {
+	register_natives_once.Do(register_natives)
+
+	path := write_program(t, debugged_program)
+	var output bytes.Buffer
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	interpreter.stdout = &output
+	interpreter.debugger = new_Console_Debugger(bufio.NewReader(strings.NewReader(commands)), &output, path)
+
+	env = new_Environment()
+	if result := interpreter.run_file(path); result != INTERPRETER_RESULT_OK {
+		t.Fatalf("The program failed with %v.", interpreter.err)
+	}
+
+	// Every pause starts with file:line and the source of the line.
+	pause := regexp.MustCompile(regexp.QuoteMeta(path) + `:(\d+)  `)
+	var lines []string
+	for _, match := range pause.FindAllStringSubmatch(output.String(), -1) {
+		lines = append(lines, match[1])
+	}
+	return lines, output.String()
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	// Stops on the first line, steps over the definition and into the call,
+	// then out of it again.
+	lines, output := run_debugged(t, "n\nn\ns\nn\nv\no\nc\n")
+
+	want := "1 4 5 1 2 5"
+	if got := strings.Join(lines, " "); got != want {
+		t.Errorf("Paused on the lines %s, expected %s:\n%s", got, want, output)
+	}
+	if !strings.Contains(output, "Scope 2 (twice):\n    x int = 1\n") {
+		t.Errorf("Expected 'vars' to show x in twice:\n%s", output)
+	}
+	if !strings.HasSuffix(output, "2\n") {
+		t.Errorf("Expected the program to print 2 once it carried on:\n%s", output)
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	lines, output := run_debugged(t, "b 3\nb 6\nc\nbt\nc\nc\n")
+
+	want := "1 3 6"
+	if got := strings.Join(lines, " "); got != want {
+		t.Errorf("Paused on the lines %s, expected %s:\n%s", got, want, output)
+	}
+	if !strings.Contains(output, "    at twice (") || !strings.Contains(output, "    at script (") {
+		t.Errorf("Expected 'bt' to show twice called from the script:\n%s", output)
+	}
+}
+
+// Once the input runs out the script runs to the end.
+func TestDebuggerWithoutInput(t *testing.T) {
+	lines, output := run_debugged(t, "")
+	if len(lines) != 1 || !strings.HasSuffix(output, "2\n") {
+		t.Errorf("Expected to pause once and then run to the end:\n%s", output)
+	}
+}
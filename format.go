@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// What goes between the braces of a placeholder in '(format ...)', like the
+// ".2" of "{:.2}" or the "1:>8" of "{1:>8}".
+//
+// The spec after the ':' is [[fill]align][0][width][.precision], where align is
+// '<', '>' or '^'. Numbers are aligned right and everything else left, unless
+// an align is given. A precision gives decimals that many digits after the
+// point, turns ints into such decimals and cuts strings down to that length.
+type Format_Spec struct {
+	fill      rune
+	align     rune
+	zero      bool
+	width     int
+	precision int
+}
+
+func parse_Format_Spec(spec string) (Format_Spec, bool) {
+	result := Format_Spec{' ', 0, false, 0, -1}
+	runes := []rune(spec)
+
+	is_align := func(r rune) bool { return r == '<' || r == '>' || r == '^' }
+	if len(runes) >= 2 && is_align(runes[1]) {
+		result.fill, result.align = runes[0], runes[1]
+		runes = runes[2:]
+	} else if len(runes) >= 1 && is_align(runes[0]) {
+		result.align = runes[0]
+		runes = runes[1:]
+	}
+
+	if len(runes) > 0 && runes[0] == '0' {
+		result.zero = true
+		runes = runes[1:]
+	}
+
+	digits := func() (int, bool) {
+		i := 0
+		for i < len(runes) && runes[i] >= '0' && runes[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, false
+		}
+		n, err := strconv.Atoi(string(runes[:i]))
+		runes = runes[i:]
+		return n, err == nil
+	}
+
+	if n, ok := digits(); ok {
+		result.width = n
+	}
+	if len(runes) > 0 && runes[0] == '.' {
+		runes = runes[1:]
+		n, ok := digits()
+		if !ok {
+			return result, false
+		}
+		result.precision = n
+	}
+
+	return result, len(runes) == 0
+}
+
+func (spec Format_Spec) apply(value Value) string {
+	text := value.String()
+	if spec.precision >= 0 {
+		if IS_NUMBER(&value) {
+			text = strconv.FormatFloat(TO_DECIMAL_S(&value), 'f', spec.precision, 64)
+		} else if utf8.RuneCountInString(text) > spec.precision {
+			text = string([]rune(text)[:spec.precision])
+		}
+	}
+
+	padding := spec.width - utf8.RuneCountInString(text)
+	if padding <= 0 {
+		return text
+	}
+
+	// Zeros go between the sign and the digits.
+	if spec.zero && spec.align == 0 && IS_NUMBER(&value) {
+		sign := ""
+		if strings.HasPrefix(text, "-") || strings.HasPrefix(text, "+") {
+			sign, text = text[:1], text[1:]
+		}
+		return sign + strings.Repeat("0", padding) + text
+	}
+
+	align := spec.align
+	if align == 0 {
+		align = '<'
+		if IS_NUMBER(&value) {
+			align = '>'
+		}
+	}
+
+	fill := string(spec.fill)
+	switch align {
+	case '>':
+		return strings.Repeat(fill, padding) + text
+	case '^':
+		return strings.Repeat(fill, padding/2) + text + strings.Repeat(fill, padding-padding/2)
+	default:
+		return text + strings.Repeat(fill, padding)
+	}
+}
+
+// Fills in the placeholders of the format string with the values. "{}" is the
+// next value and "{1}" the second one, either can have a spec after a ':'.
+// "{{" and "}}" are the braces themselves.
+func format_Values(format string, values []Value) string {
+	var builder strings.Builder
+	next, used := 0, 0
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+
+		if c == '}' {
+			if i+1 < len(format) && format[i+1] == '}' {
+				i++
+			} else {
+				runtime_error("Unmatched '}' in the format string, use '}}' for a '}'.")
+			}
+			builder.WriteByte('}')
+			continue
+		}
+
+		if c != '{' {
+			builder.WriteByte(c)
+			continue
+		}
+
+		if i+1 < len(format) && format[i+1] == '{' {
+			i++
+			builder.WriteByte('{')
+			continue
+		}
+
+		end := strings.IndexByte(format[i:], '}')
+		if end < 0 {
+			runtime_error("Unmatched '{' in the format string, use '{{' for a '{'.")
+		}
+		placeholder := format[i+1 : i+end]
+		i += end
+
+		position, spec_text, _ := strings.Cut(placeholder, ":")
+		index := next
+		if position != "" {
+			n, err := strconv.Atoi(position)
+			if err != nil || n < 0 {
+				runtime_error("'{%s}' isn't a placeholder, expected a position like {0}.", placeholder)
+			}
+			index = n
+		} else {
+			next++
+		}
+
+		if index >= len(values) {
+			runtime_error("The format string needs at least %d value(s), but got %d.", index+1, len(values))
+		}
+		if index+1 > used {
+			used = index + 1
+		}
+
+		spec, ok := parse_Format_Spec(spec_text)
+		if !ok {
+			runtime_error("'{%s}' has a spec that isn't [[fill]align][0][width][.precision].", placeholder)
+		}
+		builder.WriteString(spec.apply(values[index]))
+	}
+
+	if used < len(values) {
+		runtime_error("The format string only uses %d value(s), but got %d.", used, len(values))
+	}
+	return builder.String()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the .golden files of testdata with what the programs do now.")
+
+var register_natives_once sync.Once
+
+// Compiles and runs the program, giving back what it printed followed by the
+// compile or runtime error that stopped it.
+func run_golden_program(t *testing.T, path string) string {
+	// Every program has functions of its own, so they mustn't stay in the ftable.
+	natives := len(ftable.functions)
+	defer func() { ftable.functions = ftable.functions[:natives] }()
+
+	// What the script and the compiler print to stdout and stderr goes to the
+	// same place, and the input comes from the .input file next to the program,
+	// if it has one.
+	var output bytes.Buffer
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	interpreter.strict = strings.HasPrefix(filepath.Base(path), "strict_")
+	interpreter.stdout = &output
+	interpreter.stderr = &output
+	interpreter.stdin = strings.NewReader("")
+	if input, err := os.ReadFile(strings.TrimSuffix(path, ".tesp") + ".input"); err == nil {
+		interpreter.stdin = bytes.NewReader(input)
+	}
+
+	gen := interpreter.new_CodeGen()
+
+	chunk := gen.generate_chunk(path)
+	if gen.had_error {
+		return "== compile error ==\n" + output.String()
+	}
+
+	vm := interpreter.new_VM(&chunk)
+	defer free_VM(&vm)
+
+	env = new_Environment()
+	if run(&vm) != INTERPRETER_RESULT_OK {
+		output.WriteString("== runtime error ==\n" + vm.err.Error() + "\n")
+	}
+	return output.String()
+}
+
+// Every program in testdata has a .golden file next to it with what it should
+// print. The modules the programs import are in testdata/lib, and the programs
+// starting with 'strict_' run in strict mode.
+func TestGolden(t *testing.T) {
+	register_natives_once.Do(register_natives)
+
+	programs, err := filepath.Glob(filepath.Join("testdata", "*.tesp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(programs) == 0 {
+		t.Fatal("There are no programs in testdata.")
+	}
+
+	for _, program := range programs {
+		program := program
+		t.Run(strings.TrimSuffix(filepath.Base(program), ".tesp"), func(t *testing.T) {
+			got := run_golden_program(t, program)
+			golden := strings.TrimSuffix(program, ".tesp") + ".golden"
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0666); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run 'go test -run TestGolden -update' to create it.", err)
+			}
+			if got != string(want) {
+				t.Errorf("%s printed:\n%s\nbut %s has:\n%s", program, got, golden, want)
+			}
+		})
+	}
+}
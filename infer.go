@@ -0,0 +1,128 @@
+package main
+
+// The compiler works out the type of every expression from its parts, without
+// running anything. Whatever it can't tell is an ANY, which is what untyped
+// variables get then, and the VM checks the value when the script runs.
+
+// A variable that is in scope while compiling, with the scope it was declared in.
+type Compiled_Variable struct {
+	name       string
+	value_type ValueTypes
+	depth      int
+}
+
+func (gen *CodeGen) begin_scope() {
+	gen.emit_byte(OP_START_SCOPE)
+	gen.scope_depth++
+}
+
+func (gen *CodeGen) end_scope() {
+	gen.emit_byte(OP_END_SCOPE)
+
+	for len(gen.variables) > 0 && gen.variables[len(gen.variables)-1].depth == gen.scope_depth {
+		gen.variables = gen.variables[0 : len(gen.variables)-1]
+	}
+	gen.scope_depth--
+}
+
+// Has to be called just after the scope of the function body begins, and the
+// matching end_function just before it ends.
+func (gen *CodeGen) begin_function() {
+	gen.function_depth++
+	gen.function_scopes = append(gen.function_scopes, gen.scope_depth)
+}
+
+func (gen *CodeGen) end_function() {
+	gen.function_depth--
+	gen.function_scopes = gen.function_scopes[0 : len(gen.function_scopes)-1]
+}
+
+func (gen *CodeGen) declare_variable(name string, value_type ValueTypes) {
+	gen.variables = append(gen.variables, Compiled_Variable{name, value_type, gen.scope_depth})
+}
+
+// Variables are looked up by whoever is running when the script runs, so a
+// function can only be sure of the ones it declared itself.
+func (gen *CodeGen) variable_type(name string) ValueTypes {
+	for i := len(gen.variables) - 1; i >= 0; i-- {
+		variable := gen.variables[i]
+		if variable.name != name {
+			continue
+		}
+
+		if len(gen.function_scopes) > 0 && variable.depth < gen.function_scopes[len(gen.function_scopes)-1] {
+			return ANY
+		}
+		return variable.value_type
+	}
+
+	return ANY
+}
+
+// The type arithmetic on values of these types gives back, like the VM
+// promotes them.
+func promote_static_types(types []ValueTypes) ValueTypes {
+	if len(types) == 0 {
+		return ANY
+	}
+
+	result := types[0]
+	for _, value_type := range types {
+		if value_type > STRING {
+			return ANY
+		}
+		result = promote_types(result, value_type)
+	}
+
+	if result == NO_VALUE {
+		return ANY
+	}
+	return result
+}
+
+// The type of the list that starts with 'first_token', given the types of its
+// arguments. 'function' is the function it calls, if it's a call.
+func list_type(first_token *Token, function string, arguments []ValueTypes) ValueTypes {
+	switch first_token.t_type {
+	case TOKEN_READ_LINE:
+		return STRING
+
+	case TOKEN_PLUS, TOKEN_STAR, TOKEN_SLASH, TOKEN_DIV, TOKEN_PERCENT, TOKEN_STAR_STAR, TOKEN_AMPERSAND, TOKEN_PIPE, TOKEN_CARET:
+		return promote_static_types(arguments)
+
+	case TOKEN_MINUS:
+		if len(arguments) == 1 {
+			switch arguments[0] {
+			case INT, UINT:
+				return INT
+			case DECIMAL:
+				return DECIMAL
+			}
+			return ANY
+		}
+		return promote_static_types(arguments)
+
+	// Shifts keep the type of the value being shifted.
+	case TOKEN_LESS_LESS, TOKEN_GREATER_GREATER:
+		if len(arguments) > 0 && (arguments[0] == INT || arguments[0] == UINT) {
+			return arguments[0]
+		}
+		return ANY
+
+	case TOKEN_LESS, TOKEN_LESS_EQUAL, TOKEN_GREATER, TOKEN_GREATER_EQUAL, TOKEN_EQUAL_EQUAL, TOKEN_NOT_EQUAL, TOKEN_IS:
+		return BOOL
+
+	case TOKEN_TYPE_INT, TOKEN_TYPE_UINT, TOKEN_TYPE_DECIMAL, TOKEN_TYPE_STRING, TOKEN_TYPE_BOOL:
+		value_type, _ := type_from_Token(first_token.t_type)
+		return value_type
+
+	case TOKEN_IDENTIFER:
+		if ftable.check_if_already_exists(function) {
+			if return_type := ftable.get_entry(function).return_type; return_type != NO_VALUE {
+				return return_type
+			}
+		}
+	}
+
+	return ANY
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// What a native is allowed to touch. Every native belongs to one group, and a
+// script can only call the natives of the groups it was given.
+type Permissions byte
+
+const (
+	PERMISSION_PURE Permissions = 1 << iota
+	PERMISSION_TIME
+	PERMISSION_FILESYSTEM
+	PERMISSION_ENVIRONMENT
+	PERMISSION_PROCESS
+	PERMISSION_NET
+
+	PERMISSION_NONE Permissions = 0
+	PERMISSION_ALL  Permissions = PERMISSION_PURE | PERMISSION_TIME | PERMISSION_FILESYSTEM | PERMISSION_ENVIRONMENT | PERMISSION_PROCESS | PERMISSION_NET
+)
+
+var permission_names = []struct {
+	permission Permissions
+	name       string
+}{
+	{PERMISSION_PURE, "pure"},
+	{PERMISSION_TIME, "time"},
+	{PERMISSION_FILESYSTEM, "filesystem"},
+	{PERMISSION_ENVIRONMENT, "environment"},
+	{PERMISSION_PROCESS, "process"},
+	{PERMISSION_NET, "net"},
+}
+
+func (permissions Permissions) allows(permission Permissions) bool {
+	return permissions&permission == permission
+}
+
+func (permissions Permissions) String() string {
+	var names []string
+	for _, v := range permission_names {
+		if permissions.allows(v.permission) {
+			names = append(names, v.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Parses a list like "pure,time", "all" allows everything.
+func parse_Permissions(list string) (Permissions, error) {
+	permissions := PERMISSION_NONE
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			permissions |= PERMISSION_ALL
+			continue
+		}
+
+		found := false
+		for _, v := range permission_names {
+			if v.name == name {
+				permissions |= v.permission
+				found = true
+			}
+		}
+		if !found {
+			return PERMISSION_NONE, fmt.Errorf("unknown permission '%s'", name)
+		}
+	}
+
+	return permissions, nil
+}
+
+// Compiles and runs scripts, only letting them call the natives that are in
+// the allow-list it was built with.
+type Interpreter struct {
+	permissions Permissions
+	strict      bool
+	checked     bool
+	limits      Limits
+	search_path []string
+	debugger    Debugger
+	tracer      *Tracer
+	profiler    *Profiler
+	coverage    *Coverage
+
+	// Where scripts print to and read lines from, nil means the ones of the
+	// process. Compile errors go to stderr as well.
+	stdout io.Writer
+	stderr io.Writer
+	stdin  io.Reader
+
+	// The error that stopped the last script, if it didn't compile this is nil.
+	err *Runtime_Error
+}
+
+func new_Interpreter(permissions Permissions) *Interpreter {
+	return &Interpreter{permissions: permissions}
+}
+
+func (interpreter *Interpreter) new_CodeGen() CodeGen {
+	gen := new_CodeGen(true)
+	gen.strict = interpreter.strict
+	gen.limits = interpreter.limits
+	gen.search_path = interpreter.search_path
+	gen.permissions = interpreter.permissions
+	if interpreter.stderr != nil {
+		gen.errors = interpreter.stderr
+	}
+	return gen
+}
+
+func (interpreter *Interpreter) new_VM(chunk *Chunk) VM {
+	vm := new_VM(chunk)
+	vm.strict = interpreter.strict
+	vm.checked = interpreter.checked
+	vm.permissions = interpreter.permissions
+	vm.limits = interpreter.limits
+	vm.debugger = interpreter.debugger
+	vm.tracer = interpreter.tracer
+	vm.profiler = interpreter.profiler
+	vm.coverage = interpreter.coverage
+	if interpreter.stdout != nil {
+		vm.stdout = interpreter.stdout
+	}
+	if interpreter.stderr != nil {
+		vm.stderr = interpreter.stderr
+	}
+	if interpreter.stdin != nil {
+		vm.stdin = bufio.NewReader(interpreter.stdin)
+	}
+	return vm
+}
+
+// Gives back INTERPETER_RESULT_COMPILE_ERROR if the script didn't compile, the
+// errors have been printed by then.
+func (interpreter *Interpreter) run_file(file_path string) InterpreterResult {
+	interpreter.err = nil
+
+	gen := interpreter.new_CodeGen()
+	chunk := gen.generate_chunk(file_path)
+	if gen.had_error {
+		return INTERPETER_RESULT_COMPILE_ERROR
+	}
+
+	vm := interpreter.new_VM(&chunk)
+	defer free_VM(&vm)
+	if interpreter.tracer != nil {
+		defer interpreter.tracer.flush()
+	}
+
+	result := run(&vm)
+	if interpreter.profiler != nil {
+		interpreter.profiler.stop()
+	}
+	interpreter.err = vm.err
+	return result
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestParsePermissions(t *testing.T) {
+	permissions, err := parse_Permissions("pure, net")
+	if err != nil || permissions != PERMISSION_PURE|PERMISSION_NET {
+		t.Errorf("Got %v and %v for 'pure, net'.", permissions, err)
+	}
+
+	if permissions, _ := parse_Permissions("all"); permissions != PERMISSION_ALL {
+		t.Errorf("Got %v for 'all'.", permissions)
+	}
+
+	if _, err := parse_Permissions("pure,disk"); err == nil {
+		t.Error("'disk' isn't a permission, but it was accepted.")
+	}
+}
+
+func getenv_chunk() Chunk {
+	register_natives_once.Do(register_natives)
+
+	var chunk Chunk
+	chunk.init_chunk()
+	chunk.write_constant(STRING_VAL("HOME"), 1)
+	chunk.write_call_func(OP_CALL_FUNC, "getenv", 1, 1)
+	chunk.write_chunk(OP_EOF, 1)
+	return chunk
+}
+
+// A call the compiler couldn't check, as the native didn't exist yet, is
+// still stopped by the VM.
+func TestDeniedNativeAtRuntime(t *testing.T) {
+	chunk := getenv_chunk()
+	vm := new_Interpreter(PERMISSION_PURE).new_VM(&chunk)
+	defer free_VM(&vm)
+
+	env = new_Environment()
+	if result := run(&vm); result != INTERPRETER_RESULT_INTERPET_ERROR {
+		t.Fatalf("Calling getenv with only the pure permission gave back %d.", result)
+	}
+	want := "'getenv' needs the environment permission, which this script doesn't have."
+	if vm.err.message != want {
+		t.Errorf("Got the error %q, expected %q.", vm.err.message, want)
+	}
+}
+
+// A VM or CodeGen made without an Interpreter only gets the pure natives.
+func TestDefaultPermissions(t *testing.T) {
+	chunk := getenv_chunk()
+	vm := new_VM(&chunk)
+	defer free_VM(&vm)
+
+	env = new_Environment()
+	if result := run(&vm); result != INTERPRETER_RESULT_INTERPET_ERROR {
+		t.Errorf("A VM made with new_VM could call getenv, it gave back %d.", result)
+	}
+
+	if gen := new_CodeGen(true); gen.permissions != PERMISSION_PURE {
+		t.Errorf("A CodeGen made with new_CodeGen has the permissions %v.", gen.permissions)
+	}
+}
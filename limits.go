@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// Bounds how long a script can run for and how much memory it can use, for when
+// it can't be trusted. The zero value doesn't limit anything.
+//
+// The limits are checked between instructions, so a native or 'read-line' that
+// blocks, like one waiting for input, isn't interrupted by the deadline or the
+// context. The script stops once it gets past the call. 'http-get' is the
+// exception, it gives up on the request once the deadline or context is done.
+type Limits struct {
+	max_instructions uint64
+	deadline         time.Time
+	ctx              context.Context
+
+	max_stack      int
+	max_variables  int
+	max_call_depth int
+
+	// All the bytes ever allocated for strings and lists, not just the live ones.
+	max_bytes uint64
+}
+
+// The deadline and the context are only checked every this many instructions,
+// as they are a lot slower to check than running an instruction.
+const LIMITS_CHECK_INTERVAL = 1024
+
+// Called before every instruction, stopping the script once a limit is hit.
+func (vm *VM) check_limits() {
+	vm.instructions++
+
+	if vm.limits.max_instructions != 0 && vm.instructions > vm.limits.max_instructions {
+		stop_execution(INTERPRETER_RESULT_INSTRUCTION_LIMIT, "Ran more than the limit of %d instructions.", vm.limits.max_instructions)
+	}
+
+	if vm.limits.max_stack != 0 && len(vm.stack.values) > vm.limits.max_stack {
+		runtime_error("The stack grew past the limit of %d values.", vm.limits.max_stack)
+	}
+
+	if vm.limits.max_variables != 0 && len(env.Entries) > vm.limits.max_variables {
+		runtime_error("More than the limit of %d variables are alive.", vm.limits.max_variables)
+	}
+
+	if vm.instructions%LIMITS_CHECK_INTERVAL != 0 {
+		return
+	}
+	vm.limits.check_deadline()
+}
+
+func (limits *Limits) check_deadline() {
+	if !limits.deadline.IsZero() && time.Now().After(limits.deadline) {
+		stop_execution(INTERPRETER_RESULT_TIMEOUT, "Ran past the deadline.")
+	}
+
+	if limits.ctx != nil {
+		if err := limits.ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+			stop_execution(INTERPRETER_RESULT_TIMEOUT, "Ran past the deadline.")
+		} else if err != nil {
+			stop_execution(INTERPRETER_RESULT_CANCELLED, "The script was cancelled.")
+		}
+	}
+}
+
+// The limits of the script calling a native, so the natives that wait on
+// something can give up when the script has to stop.
+var native_limits Limits
+
+// A context that is done once the deadline has passed or the context of the
+// limits is done.
+func (limits *Limits) context() (context.Context, context.CancelFunc) {
+	ctx := limits.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !limits.deadline.IsZero() {
+		return context.WithDeadline(ctx, limits.deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (vm *VM) check_call_depth() {
+	if vm.limits.max_call_depth != 0 && len(vm.function_jump_back) >= vm.limits.max_call_depth {
+		runtime_error("Calls went deeper than the limit of %d.", vm.limits.max_call_depth)
+	}
+}
+
+// Counts the memory of a string or list that was just made towards max_bytes.
+// The elements of a list are counted when they are made, so only the list
+// itself is counted here.
+func (vm *VM) allocated(value *Value) {
+	switch value.value_type {
+	case STRING:
+		vm.allocated_bytes += uint64(len(value.as.STR))
+	case LIST:
+		vm.allocated_bytes += uint64(len(value.as.LST)) * uint64(unsafe.Sizeof(Value{}))
+	default:
+		return
+	}
+
+	if vm.limits.max_bytes != 0 && vm.allocated_bytes > vm.limits.max_bytes {
+		runtime_error("Allocated more than the limit of %d bytes for strings and lists.", vm.limits.max_bytes)
+	}
+}
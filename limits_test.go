@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Runs the program with the limits, giving back how it ended and the error
+// that stopped it.
+func run_limited(t *testing.T, limits Limits, program string) (InterpreterResult, *Runtime_Error) {
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	interpreter.limits = limits
+
+	chunk, had_error, errors := compile_program(t, interpreter, program)
+	if had_error {
+		t.Fatalf("The program didn't compile:\n%s", errors)
+	}
+
+	vm := interpreter.new_VM(&chunk)
+	defer free_VM(&vm)
+
+	env = new_Environment()
+	return run(&vm), vm.err
+}
+
+// Never stops on its own.
+const runaway_loop = "(var i 0)\n(while true (assign i (+ i 1)))"
+
+func TestExecutionLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel_expired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel_expired()
+
+	tests := []struct {
+		name    string
+		limits  Limits
+		result  InterpreterResult
+		message string
+	}{
+		{"instructions", Limits{max_instructions: 1000}, INTERPRETER_RESULT_INSTRUCTION_LIMIT, "Ran more than the limit of 1000 instructions."},
+		{"deadline", Limits{deadline: time.Now().Add(10 * time.Millisecond)}, INTERPRETER_RESULT_TIMEOUT, "Ran past the deadline."},
+		{"cancelled context", Limits{ctx: cancelled}, INTERPRETER_RESULT_CANCELLED, "The script was cancelled."},
+		{"expired context", Limits{ctx: expired}, INTERPRETER_RESULT_TIMEOUT, "Ran past the deadline."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := run_limited(t, test.limits, runaway_loop)
+			if result != test.result {
+				t.Fatalf("The loop ended with %d, expected %d.", result, test.result)
+			}
+			if err.message != test.message {
+				t.Errorf("Got the error %q, expected %q.", err.message, test.message)
+			}
+		})
+	}
+}
+
+// Limits that aren't hit don't change how a script ends.
+func TestWithinLimits(t *testing.T) {
+	limits := Limits{max_instructions: 1000, deadline: time.Now().Add(time.Minute), ctx: context.Background()}
+	if result, err := run_limited(t, limits, "(var i (+ 1 2))"); result != INTERPRETER_RESULT_OK {
+		t.Errorf("The script ended with %v.", err)
+	}
+}
+
+// Calls itself forever, with a new variable for every call.
+const runaway_recursion = "(func f [n int] (f (+ n 1)))\n(f 0)"
+
+func TestMemoryLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		program string
+		message string
+	}{
+		// Every iteration leaves the 1 on the stack.
+		{"stack", Limits{max_stack: 100}, "(while true 1)", "The stack grew past the limit of 100 values."},
+		{"variables", Limits{max_variables: 50}, runaway_recursion, "More than the limit of 50 variables are alive."},
+		{"call depth", Limits{max_call_depth: 50}, runaway_recursion, "Calls went deeper than the limit of 50."},
+		{"bytes", Limits{max_bytes: 1 << 20}, "(var s \"x\")\n(while true (assign s (+ s s)))", "Allocated more than the limit of 1048576 bytes for strings and lists."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := run_limited(t, test.limits, test.program)
+			if result != INTERPRETER_RESULT_INTERPET_ERROR {
+				t.Fatalf("The script ended with %d, expected a runtime error.", result)
+			}
+			if err.message != test.message {
+				t.Errorf("Got the error %q, expected %q.", err.message, test.message)
+			}
+		})
+	}
+}
+
+// A request that doesn't get an answer is given up on at the deadline, instead
+// of keeping the host waiting.
+func TestHttpGetDeadline(t *testing.T) {
+	register_natives_once.Do(register_natives)
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	interpreter := new_Interpreter(PERMISSION_PURE | PERMISSION_NET)
+	interpreter.limits = Limits{deadline: time.Now().Add(100 * time.Millisecond)}
+
+	chunk, had_error, errors := compile_program(t, interpreter, `(println (http-get "`+server.URL+`"))`)
+	if had_error {
+		t.Fatalf("The program didn't compile:\n%s", errors)
+	}
+
+	vm := interpreter.new_VM(&chunk)
+	defer free_VM(&vm)
+
+	env = new_Environment()
+	start := time.Now()
+	if result := run(&vm); result != INTERPRETER_RESULT_TIMEOUT {
+		t.Fatalf("The request ended with %d, expected %d: %v", result, INTERPRETER_RESULT_TIMEOUT, vm.err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("The request took %s to give up.", elapsed)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"runtime"
+	"time"
 )
 
 func actually_fibonacci(n int) int {
@@ -24,10 +26,9 @@ func fibonacci(eval bool, values []Value) (Value, ValueTypes) {
 
 func clock(eval bool, values []Value) (Value, ValueTypes) {
 	// this function takes 0 args
+	dt := time.Now()
 
-	//dt := time.Now()
-
-	return NO_VAL(), NO_VALUE
+	return DECIMAL_VAL(float64(dt.UnixNano()) / 1e9), DECIMAL
 }
 
 func PrintMemUsage() {
@@ -44,21 +45,46 @@ func bToMb(b uint64) uint64 {
 	return b / 1024 / 1024
 }
 
-func main() {
-	//args := os.Args // Add [1:] when doing the thing, or just don't.
-	//defer fmt.Println(args[0])
+func register_natives() {
+	ftable.add_native_entry("fibonacci", fibonacci, 1, INT, PERMISSION_PURE)
+	ftable.add_native_entry("type-of", native_type_of, 1, STRING, PERMISSION_PURE)
+	ftable.add_native_entry("len", native_len, 1, INT, PERMISSION_PURE)
+	ftable.add_native_entry("get", native_get, 2, NO_VALUE, PERMISSION_PURE)
+	ftable.add_variadic_native_entry("format", native_format, 1, ARITY_UNLIMITED, STRING, PERMISSION_PURE)
+	ftable.add_variadic_native_entry("assert", native_assert, 1, 2, NO_VALUE, PERMISSION_PURE)
+	ftable.add_variadic_native_entry("assert-eq", native_assert_eq, 2, 3, NO_VALUE, PERMISSION_PURE)
 
-	gen := new_CodeGen(true)
+	ftable.add_native_entry("clock", clock, 0, DECIMAL, PERMISSION_TIME)
+	ftable.add_native_entry("read-file", native_read_file, 1, STRING, PERMISSION_FILESYSTEM)
+	ftable.add_native_entry("write-file", native_write_file, 2, NO_VALUE, PERMISSION_FILESYSTEM)
+	ftable.add_native_entry("getenv", native_getenv, 1, STRING, PERMISSION_ENVIRONMENT)
+	ftable.add_native_entry("exit", native_exit, 1, NO_VALUE, PERMISSION_PROCESS)
+	ftable.add_native_entry("http-get", native_http_get, 1, STRING, PERMISSION_NET)
+}
 
-	ftable.add_native_entry("fibonacci", fibonacci, 1, INT)
-	ftable.add_native_entry("clock", clock, 0, NO_VALUE)
-	chunk := gen.generate_chunk("./test.txt")
-	//body := ftable.get_entry("add").body
-	//disassemble_chunk(&body, "ADD FUNC")
-	//disassemble_chunk(&chunk, "GENERATED CHUNK")
+func main() {
+	args := os.Args[1:]
 
-	vm := new_VM(&chunk)
-	defer free_VM(&vm)
+	// Without a command the arguments are for run, so `tesp file` still works.
+	command := "run"
+	if len(args) > 0 {
+		switch args[0] {
+		case "run", "test", "tokens", "debug", "dap":
+			command = args[0]
+			args = args[1:]
+		}
+	}
 
-	interpret(&vm)
+	switch command {
+	case "test":
+		os.Exit(test_command(args))
+	case "tokens":
+		os.Exit(tokens_command(args))
+	case "debug":
+		os.Exit(debug_command(args))
+	case "dap":
+		os.Exit(dap_command(args))
+	default:
+		os.Exit(run_command(args))
+	}
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A file that has been compiled, either the script itself or something it
+// imported. The functions of an imported module are put in the ftable with the
+// prefix of the module, so modules can't clash with each other or the script.
+type Module struct {
+	name      string
+	path      string
+	prefix    string
+	functions map[string]bool
+	aliases   map[string]*Module
+	compiling bool
+
+	// Every function the module defines, found before compiling it, so calls
+	// to the ones further down know they are the module's.
+	declared map[string]bool
+}
+
+func new_Module(name string, path string, prefix string) *Module {
+	return &Module{
+		name,
+		path,
+		prefix,
+		make(map[string]bool),
+		make(map[string]*Module),
+		false,
+		make(map[string]bool),
+	}
+}
+
+// Finds the names of the functions the source defines, without compiling it.
+func (module *Module) declare_functions(source []byte) {
+	tokens := new_Lexer(source).Tokens()
+	for i := 2; i < len(tokens); i++ {
+		if tokens[i-2].t_type == TOKEN_LEFT_PAREN && tokens[i-1].t_type == TOKEN_FUNC && tokens[i].t_type == TOKEN_IDENTIFER {
+			module.declared[tokens[i].lexeme] = true
+		}
+	}
+}
+
+// Gives back the name a function of this module has in the ftable.
+func (module *Module) qualify(name string) string {
+	if module.prefix == "" {
+		return name
+	}
+	return module.prefix + "." + name
+}
+
+// Functions starting with '_' can only be called from inside of their module.
+func is_private_name(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// Modules are looked up next to the file importing them, in each directory of
+// the search path and then next to the script. Paths starting with './' or
+// '../' only look next to the file importing them.
+func (gen *CodeGen) resolve_module_path(import_path string) (string, bool) {
+	var candidates []string
+	if filepath.IsAbs(import_path) {
+		candidates = append(candidates, import_path)
+	} else {
+		candidates = append(candidates, filepath.Join(filepath.Dir(gen.module.path), import_path))
+		if !strings.HasPrefix(import_path, "./") && !strings.HasPrefix(import_path, "../") {
+			for _, dir := range gen.search_path {
+				candidates = append(candidates, filepath.Join(dir, import_path))
+			}
+			candidates = append(candidates, filepath.Join(filepath.Dir(gen.importing[0].path), import_path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			if path, err := filepath.Abs(candidate); err == nil {
+				return path, true
+			}
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+// Every module gets a prefix based on its file name, with a number added when
+// two modules have the same file name.
+func (gen *CodeGen) module_prefix(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	prefix := base
+
+	for i := 2; ; i++ {
+		taken := false
+		for _, module := range gen.modules {
+			if module.prefix == prefix {
+				taken = true
+				break
+			}
+		}
+
+		if !taken {
+			return prefix
+		}
+		prefix = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+func (gen *CodeGen) import_cycle(module *Module) string {
+	cycle := module.name
+	for i := len(gen.importing) - 1; i >= 0; i-- {
+		cycle = gen.importing[i].name + " -> " + cycle
+		if gen.importing[i] == module {
+			break
+		}
+	}
+	return cycle
+}
+
+// Compiles '(import "path" as alias)'. A module is only compiled the first time
+// it gets imported, its code runs right there with variables of its own.
+func (gen *CodeGen) import_module(path_token *Token, alias_token *Token) {
+	if gen.function_depth > 0 {
+		gen.error_at(path_token, "Modules can only be imported outside of functions.")
+		return
+	}
+	// The module is only compiled once, so its code has to run exactly once too.
+	if gen.branch_depth > 0 {
+		gen.error_at(path_token, "Modules cannot be imported inside of an if, while or try.")
+		return
+	}
+
+	alias := alias_token.lexeme
+	if strings.Contains(alias, ".") {
+		gen.error_at(alias_token, "The name of a module cannot contain a '.'.")
+		return
+	}
+	if _, exists := gen.module.aliases[alias]; exists {
+		gen.error_at(alias_token, fmt.Sprintf("A module was already imported as '%s'.", alias))
+		return
+	}
+
+	path, ok := gen.resolve_module_path(path_token.lexeme)
+	if !ok {
+		gen.error_at(path_token, fmt.Sprintf("Cannot find the module '%s'.", path_token.lexeme))
+		return
+	}
+
+	module, cached := gen.modules[path]
+	if cached && module.compiling {
+		gen.error_at(path_token, "Import cycle: "+gen.import_cycle(module))
+		return
+	}
+
+	if !cached {
+		module = new_Module(path_token.lexeme, path, gen.module_prefix(path))
+		gen.modules[path] = module
+		gen.compile_module(path_token, module)
+	}
+
+	gen.module.aliases[alias] = module
+}
+
+func (gen *CodeGen) compile_module(path_token *Token, module *Module) {
+	source, err := os.ReadFile(module.path)
+	if err != nil {
+		gen.error_at(path_token, fmt.Sprintf("Cannot read the module '%s'.", module.name))
+		return
+	}
+	lexer := new_Lexer(source)
+	module.declare_functions(source)
+
+	outer_lexer, outer_module := gen.lexer, gen.module
+	current, previous := gen.current, gen.previous
+
+	// The module doesn't see the variables of whoever imported it.
+	variables, scope_depth := gen.variables, gen.scope_depth
+	gen.variables, gen.scope_depth = nil, 0
+
+	gen.lexer, gen.module = lexer, module
+	gen.importing = append(gen.importing, module)
+	gen.chunk.set_file(module.name, module.path)
+	module.compiling = true
+
+	gen.advance_g()
+	gen.chunk.write_load(OP_START_MODULE, module.prefix, uint32(gen.previous.line))
+	for gen.current.t_type != TOKEN_EOF {
+		gen.expression()
+	}
+	gen.chunk.write_load(OP_END_MODULE, outer_module.prefix, uint32(gen.previous.line))
+
+	module.compiling = false
+	gen.importing = gen.importing[:len(gen.importing)-1]
+
+	gen.lexer, gen.module = outer_lexer, outer_module
+	gen.current, gen.previous = current, previous
+	gen.variables, gen.scope_depth = variables, scope_depth
+	gen.chunk.set_file(outer_module.name, outer_module.path)
+}
+
+// Works out which function in the ftable a call refers to. Inside of a module
+// its own functions come first, even the ones that are defined further down,
+// then the script's and the natives. A name nobody has yet is taken to be a
+// function of the module that comes later.
+func (gen *CodeGen) resolve_function(token *Token) string {
+	name := token.lexeme
+
+	if alias, function, found := strings.Cut(name, "."); found {
+		module, ok := gen.module.aliases[alias]
+		if !ok {
+			gen.error_at(token, fmt.Sprintf("There is no module imported as '%s'.", alias))
+			return name
+		}
+
+		if is_private_name(function) {
+			gen.error_at(token, fmt.Sprintf("'%s' is private to the module '%s'.", function, module.name))
+		} else if !module.functions[function] {
+			gen.error_at(token, fmt.Sprintf("The module '%s' has no function '%s'.", module.name, function))
+		}
+		return module.qualify(function)
+	}
+
+	qualified := gen.module.qualify(name)
+	if ftable.check_if_already_exists(qualified) || gen.module.declared[name] || !ftable.check_if_already_exists(name) {
+		return qualified
+	}
+	return name
+}
+
+// The variables of every module while another one is running, by the prefix of
+// the module, the script's are under "". Every module keeps its own, so its
+// functions see its top-level variables and not the ones of whoever called them.
+var module_environments = make(map[string]Environment)
+
+// Puts the variables of the module in 'env', keeping the ones that were there
+// for when their module runs again. Gives back that module.
+func switch_Environment(module string) string {
+	outer := env.module
+	module_environments[outer] = env
+	env = module_environments[module]
+	env.module = module
+	return outer
+}
+
+// What the script and its modules have defined at some point, like after the
+// code outside of the tests ran.
+type Environment_Snapshot struct {
+	script  Environment
+	modules map[string]Environment
+}
+
+func snapshot_Environments() Environment_Snapshot {
+	snapshot := Environment_Snapshot{env.copy(), make(map[string]Environment)}
+	for k, v := range module_environments {
+		snapshot.modules[k] = v.copy()
+	}
+	return snapshot
+}
+
+// Puts back a copy of the snapshot, so it can be restored again.
+func (snapshot *Environment_Snapshot) restore() {
+	env = snapshot.script.copy()
+	module_environments = make(map[string]Environment)
+	for k, v := range snapshot.modules {
+		module_environments[k] = v.copy()
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+func native_type_of(eval bool, values []Value) (Value, ValueTypes) {
+	return STRING_VAL(ValueTypes_to_string(values[0].value_type)), STRING
+}
+
+// Natives that work on the lists made by rest arguments.
+
+func native_len(eval bool, values []Value) (Value, ValueTypes) {
+	switch values[0].value_type {
+	case LIST:
+		return INT_VAL(int64(len(values[0].as.LST))), INT
+	case STRING:
+		return INT_VAL(int64(len(values[0].as.STR))), INT
+	}
+
+	runtime_error("Cannot get the length of %s!", ValueTypes_with_article(values[0].value_type))
+	return NO_VAL(), NO_VALUE
+}
+
+func native_get(eval bool, values []Value) (Value, ValueTypes) {
+	if !IS_OF_TYPE(&values[0], LIST) {
+		runtime_error("Cannot index into %s!", ValueTypes_with_article(values[0].value_type))
+	}
+
+	list := values[0].as.LST
+	index := TO_INT_S(&values[1])
+	if index < 0 || index >= int64(len(list)) {
+		runtime_error("Index %d is out of range for a list of length %d.", index, len(list))
+	}
+
+	return list[index], list[index].value_type
+}
+
+func native_format(eval bool, values []Value) (Value, ValueTypes) {
+	if !IS_OF_TYPE(&values[0], STRING) {
+		runtime_error("Expected a string to format, but got %s.", ValueTypes_with_article(values[0].value_type))
+	}
+	return STRING_VAL(format_Values(values[0].as.STR, values[1:])), STRING
+}
+
+// Natives for tests, which fail like any runtime error so the line of the
+// assert is reported. They don't check anything while the compiler evaluates.
+
+func native_assert(eval bool, values []Value) (Value, ValueTypes) {
+	if eval {
+		return NO_VAL(), NO_VALUE
+	}
+
+	if !IS_OF_TYPE(&values[0], BOOL) {
+		runtime_error("Expected a bool to assert, but got %s.", ValueTypes_with_article(values[0].value_type))
+	}
+
+	if !TO_BOOL_S(&values[0]) {
+		if len(values) > 1 {
+			runtime_error("Assertion failed: %s", values[1].String())
+		}
+		runtime_error("Assertion failed.")
+	}
+	return NO_VAL(), NO_VALUE
+}
+
+func native_assert_eq(eval bool, values []Value) (Value, ValueTypes) {
+	if eval {
+		return NO_VAL(), NO_VALUE
+	}
+
+	if !values_equal(values[0], values[1]) {
+		message := fmt.Sprintf("Expected %s (%s) to equal %s (%s).", values[0].String(), ValueTypes_to_string(values[0].value_type), values[1].String(), ValueTypes_to_string(values[1].value_type))
+		if len(values) > 2 {
+			message = values[2].String() + ": " + message
+		}
+		runtime_error("Assertion failed: %s", message)
+	}
+	return NO_VAL(), NO_VALUE
+}
+
+// Numbers are equal when they are once they have been promoted to the same
+// type, everything else has to be of the same type.
+func values_equal(a Value, b Value) bool {
+	if IS_NUMBER(&a) && IS_NUMBER(&b) {
+		switch promote_types(a.value_type, b.value_type) {
+		case INT:
+			return TO_INT_S(&a) == TO_INT_S(&b)
+		case UINT:
+			return TO_UINT_S(&a) == TO_UINT_S(&b)
+		default:
+			return TO_DECIMAL_S(&a) == TO_DECIMAL_S(&b)
+		}
+	}
+
+	if a.value_type != b.value_type {
+		return false
+	}
+
+	switch a.value_type {
+	case STRING:
+		return TO_STRING_S(&a) == TO_STRING_S(&b)
+	case BOOL:
+		return TO_BOOL_S(&a) == TO_BOOL_S(&b)
+	case LIST:
+		if len(a.as.LST) != len(b.as.LST) {
+			return false
+		}
+		for i := range a.as.LST {
+			if !values_equal(a.as.LST[i], b.as.LST[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Natives with side effects do nothing while the compiler is evaluating, which
+// is when 'eval' is true.
+
+func native_read_file(eval bool, values []Value) (Value, ValueTypes) {
+	if eval {
+		return STRING_VAL(""), STRING
+	}
+
+	contents, err := os.ReadFile(TO_STRING_S(&values[0]))
+	if err != nil {
+		runtime_error("Cannot read the file: %s", err)
+	}
+	return STRING_VAL(string(contents)), STRING
+}
+
+func native_write_file(eval bool, values []Value) (Value, ValueTypes) {
+	if eval {
+		return NO_VAL(), NO_VALUE
+	}
+
+	if err := os.WriteFile(TO_STRING_S(&values[0]), []byte(TO_STRING_S(&values[1])), 0666); err != nil {
+		runtime_error("Cannot write the file: %s", err)
+	}
+	return NO_VAL(), NO_VALUE
+}
+
+func native_getenv(eval bool, values []Value) (Value, ValueTypes) {
+	if eval {
+		return STRING_VAL(""), STRING
+	}
+
+	return STRING_VAL(os.Getenv(TO_STRING_S(&values[0]))), STRING
+}
+
+// Stops the script instead of the process, so whatever ran it can still clean
+// up and decide what the status means.
+func native_exit(eval bool, values []Value) (Value, ValueTypes) {
+	if !eval {
+		exit_execution(int(TO_INT_S(&values[0])))
+	}
+	return NO_VAL(), NO_VALUE
+}
+
+// Even without any limits a request doesn't wait longer than this.
+const HTTP_TIMEOUT = 30 * time.Second
+
+var http_client = &http.Client{Timeout: HTTP_TIMEOUT}
+
+// The request is given up on when the script runs past its deadline or its
+// context is done, so it can't keep whoever runs the script waiting.
+func native_http_get(eval bool, values []Value) (Value, ValueTypes) {
+	if eval {
+		return STRING_VAL(""), STRING
+	}
+
+	ctx, cancel := native_limits.context()
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, TO_STRING_S(&values[0]), nil)
+	if err != nil {
+		runtime_error("Cannot get the URL: %s", err)
+	}
+
+	response, err := http_client.Do(request)
+	if err != nil {
+		native_limits.check_deadline()
+		runtime_error("Cannot get the URL: %s", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		native_limits.check_deadline()
+		runtime_error("Cannot read the response: %s", err)
+	}
+	if response.StatusCode >= 400 {
+		runtime_error("Cannot get the URL: %s", response.Status)
+	}
+	return STRING_VAL(string(body)), STRING
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Counts the instructions the VM runs and how long they take, per function, per
+// line and per opcode. The time from one instruction to the next goes to the
+// first of them, so the time spent in a native goes to the call of it.
+type Profiler struct {
+	// What a function spends itself, and with the functions it calls.
+	functions  map[string]*Profile_Counts
+	cumulative map[string]*Profile_Counts
+	lines      map[string]*Profile_Counts
+	opcodes    map[string]*Profile_Counts
+
+	// The same counts for every stack of calls, for the pprof profile.
+	samples map[Profile_Sample_Key]*Profile_Sample
+
+	// What the calls outside of the running function add to the key of a
+	// sample, for every depth. These only change on calls and returns.
+	callers []Profile_Caller
+
+	// The counts of the opcode at each offset.
+	opcodes_at map[Profile_Offset]*Profile_Counts
+
+	total   Profile_Counts
+	started time.Time
+	stopped time.Time
+
+	// The counts the time since the last instruction goes to.
+	last    time.Time
+	pending []*Profile_Counts
+}
+
+type Profile_Counts struct {
+	instructions uint64
+	nanoseconds  int64
+}
+
+type Profile_Sample struct {
+	Profile_Counts
+	frames []Stack_Frame
+
+	// Everything an instruction with this stack counts towards, besides the
+	// total and its opcode.
+	counts []*Profile_Counts
+}
+
+type Profile_Sample_Key struct {
+	callers  string
+	function string
+	path     string
+	line     uint32
+}
+
+type Profile_Caller struct {
+	function     string
+	return_index uint32
+	key          string
+}
+
+type Profile_Offset struct {
+	chunk  *Chunk
+	offset uint32
+}
+
+func new_Profiler() *Profiler {
+	return &Profiler{
+		functions:  make(map[string]*Profile_Counts),
+		cumulative: make(map[string]*Profile_Counts),
+		lines:      make(map[string]*Profile_Counts),
+		opcodes:    make(map[string]*Profile_Counts),
+		samples:    make(map[Profile_Sample_Key]*Profile_Sample),
+		opcodes_at: make(map[Profile_Offset]*Profile_Counts),
+	}
+}
+
+func profile_counts(counts map[string]*Profile_Counts, key string) *Profile_Counts {
+	v, ok := counts[key]
+	if !ok {
+		v = &Profile_Counts{}
+		counts[key] = v
+	}
+	return v
+}
+
+// Called with vm.index at the instruction the VM is about to run.
+func (profiler *Profiler) before_instruction(vm *VM) {
+	now := time.Now()
+	if profiler.started.IsZero() {
+		profiler.started = now
+	}
+	profiler.charge(now)
+
+	// Code the compiler adds around the script has no line, and the end of it
+	// is on the line after the last one. Neither is counted.
+	line := vm.chunk.lines[vm.index]
+	if line == 0 || vm.chunk.code[vm.index] == OP_EOF {
+		profiler.pending = profiler.pending[:0]
+		profiler.last = time.Now()
+		return
+	}
+
+	file := vm.chunk.file_at(vm.index)
+	key := Profile_Sample_Key{profiler.callers_key(vm), vm.current_function(), file.path, line}
+	sample, ok := profiler.samples[key]
+	if !ok {
+		sample = profiler.new_Sample(vm, file.name)
+		profiler.samples[key] = sample
+	}
+
+	offset := Profile_Offset{vm.chunk, vm.index}
+	opcode, ok := profiler.opcodes_at[offset]
+	if !ok {
+		opcode = profile_counts(profiler.opcodes, decode_instruction(vm.chunk, uint64(vm.index)).name)
+		profiler.opcodes_at[offset] = opcode
+	}
+
+	profiler.pending = append(profiler.pending[:0], &profiler.total, opcode)
+	profiler.pending = append(profiler.pending, sample.counts...)
+	for _, v := range profiler.pending {
+		v.instructions++
+	}
+
+	// The time spent in here isn't the script's.
+	profiler.last = time.Now()
+}
+
+// Gives back the key of the calls that lead to the running function, only
+// working out the part of it for the calls that changed since the last time.
+func (profiler *Profiler) callers_key(vm *VM) string {
+	depth := len(vm.function_jump_back)
+
+	same := 0
+	for same < depth && same < len(profiler.callers) &&
+		profiler.callers[same].function == vm.function_names[same] &&
+		profiler.callers[same].return_index == vm.function_jump_back[same] {
+		same++
+	}
+
+	profiler.callers = profiler.callers[:same]
+	for i := same; i < depth; i++ {
+		key := ""
+		if i > 0 {
+			key = profiler.callers[i-1].key
+		}
+		key += fmt.Sprintf("%s\x00%d\x00", vm.function_names[i], vm.function_jump_back[i])
+		profiler.callers = append(profiler.callers, Profile_Caller{vm.function_names[i], vm.function_jump_back[i], key})
+	}
+
+	if depth == 0 {
+		return ""
+	}
+	return profiler.callers[depth-1].key
+}
+
+func (profiler *Profiler) new_Sample(vm *VM, file string) *Profile_Sample {
+	frames := vm.stack_trace(vm.index)
+	sample := &Profile_Sample{Profile_Counts{}, frames, nil}
+
+	sample.counts = append(sample.counts,
+		&sample.Profile_Counts,
+		profile_counts(profiler.functions, frames[0].function),
+		profile_counts(profiler.lines, fmt.Sprintf("%s:%d", file, frames[0].line)),
+	)
+
+	// Recursive functions only count once for their cumulative time.
+	seen := make(map[string]bool)
+	for _, frame := range frames {
+		if !seen[frame.function] {
+			seen[frame.function] = true
+			sample.counts = append(sample.counts, profile_counts(profiler.cumulative, frame.function))
+		}
+	}
+	return sample
+}
+
+func (profiler *Profiler) charge(now time.Time) {
+	if !profiler.last.IsZero() {
+		elapsed := now.Sub(profiler.last).Nanoseconds()
+		for _, v := range profiler.pending {
+			v.nanoseconds += elapsed
+		}
+	}
+	profiler.last = now
+}
+
+// Gives the last instruction its time, once the script is done.
+func (profiler *Profiler) stop() {
+	profiler.stopped = time.Now()
+	profiler.charge(profiler.stopped)
+	profiler.pending = nil
+}
+
+type profile_row struct {
+	name   string
+	counts Profile_Counts
+	cum    *Profile_Counts
+}
+
+// The rows with the most time first.
+func sorted_rows(counts map[string]*Profile_Counts) []profile_row {
+	var rows []profile_row
+	for k, v := range counts {
+		rows = append(rows, profile_row{k, *v, nil})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].counts.nanoseconds != rows[j].counts.nanoseconds {
+			return rows[i].counts.nanoseconds > rows[j].counts.nanoseconds
+		}
+		return rows[i].name < rows[j].name
+	})
+	return rows
+}
+
+// How many rows of each table the report shows.
+const PROFILE_REPORT_ROWS = 20
+
+func (profiler *Profiler) write_report(writer io.Writer) {
+	fmt.Fprintf(writer, "%d instructions in %v\n", profiler.total.instructions, time.Duration(profiler.total.nanoseconds))
+
+	functions := sorted_rows(profiler.functions)
+	for i := range functions {
+		functions[i].cum = profiler.cumulative[functions[i].name]
+	}
+	// Functions that only call others never run an instruction of their own.
+	for name, v := range profiler.cumulative {
+		if _, ok := profiler.functions[name]; !ok {
+			functions = append(functions, profile_row{name, Profile_Counts{}, v})
+		}
+	}
+
+	profiler.write_table(writer, "Function", functions)
+	profiler.write_table(writer, "Line", sorted_rows(profiler.lines))
+	profiler.write_table(writer, "Opcode", sorted_rows(profiler.opcodes))
+}
+
+func (profiler *Profiler) write_table(writer io.Writer, title string, rows []profile_row) {
+	fmt.Fprintf(writer, "\n%-30s %14s %7s %12s %7s", title, "instructions", "%", "time", "%")
+	if len(rows) > 0 && rows[0].cum != nil {
+		fmt.Fprintf(writer, " %12s %7s", "cum time", "%")
+	}
+	fmt.Fprintln(writer)
+
+	percent := func(part int64, total int64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(part) * 100 / float64(total)
+	}
+
+	for i, row := range rows {
+		if i == PROFILE_REPORT_ROWS {
+			fmt.Fprintf(writer, "... %d more\n", len(rows)-i)
+			break
+		}
+
+		fmt.Fprintf(writer, "%-30s %14d %6.2f%% %12v %6.2f%%", row.name,
+			row.counts.instructions, percent(int64(row.counts.instructions), int64(profiler.total.instructions)),
+			time.Duration(row.counts.nanoseconds), percent(row.counts.nanoseconds, profiler.total.nanoseconds))
+		if row.cum != nil {
+			fmt.Fprintf(writer, " %12v %6.2f%%", time.Duration(row.cum.nanoseconds), percent(row.cum.nanoseconds, profiler.total.nanoseconds))
+		}
+		fmt.Fprintln(writer)
+	}
+}
+
+// Writes the profile in the gzipped protobuf format of pprof, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto for the fields.
+func (profiler *Profiler) write_pprof(path string) error {
+	var string_table []string
+	string_index := make(map[string]uint64)
+	intern := func(s string) uint64 {
+		i, ok := string_index[s]
+		if !ok {
+			i = uint64(len(string_table))
+			string_table = append(string_table, s)
+			string_index[s] = i
+		}
+		return i
+	}
+	intern("")
+
+	type function_key struct{ name, path string }
+	functions := make(map[function_key]uint64)
+	locations := make(map[Stack_Frame]uint64)
+
+	var profile, function_table, location_table proto_buffer
+
+	sample_type := func(field int, kind string, unit string) {
+		var value_type proto_buffer
+		value_type.uint64_field(1, intern(kind))
+		value_type.uint64_field(2, intern(unit))
+		profile.bytes_field(field, value_type.Bytes())
+	}
+	sample_type(1, "instructions", "count")
+	sample_type(1, "time", "nanoseconds")
+
+	// In the same order every time, from the outermost frame in.
+	var samples []*Profile_Sample
+	for _, v := range profiler.samples {
+		samples = append(samples, v)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i].frames, samples[j].frames
+		for k := 1; k <= len(a) && k <= len(b); k++ {
+			x, y := a[len(a)-k], b[len(b)-k]
+			if x != y {
+				if x.path != y.path {
+					return x.path < y.path
+				}
+				if x.line != y.line {
+					return x.line < y.line
+				}
+				return x.function < y.function
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	for _, sample := range samples {
+
+		var ids []uint64
+		for _, frame := range sample.frames {
+			frame.file = ""
+			id, ok := locations[frame]
+			if !ok {
+				function_id, ok := functions[function_key{frame.function, frame.path}]
+				if !ok {
+					function_id = uint64(len(functions) + 1)
+					functions[function_key{frame.function, frame.path}] = function_id
+
+					var function proto_buffer
+					function.uint64_field(1, function_id)
+					function.uint64_field(2, intern(frame.function))
+					function.uint64_field(3, intern(frame.function))
+					function.uint64_field(4, intern(frame.path))
+					function_table.bytes_field(5, function.Bytes())
+				}
+
+				id = uint64(len(locations) + 1)
+				locations[frame] = id
+
+				var line proto_buffer
+				line.uint64_field(1, function_id)
+				line.uint64_field(2, uint64(frame.line))
+
+				var location proto_buffer
+				location.uint64_field(1, id)
+				location.bytes_field(4, line.Bytes())
+				location_table.bytes_field(4, location.Bytes())
+			}
+			ids = append(ids, id)
+		}
+
+		var encoded proto_buffer
+		encoded.packed_field(1, ids)
+		encoded.packed_field(2, []uint64{sample.instructions, uint64(sample.nanoseconds)})
+		profile.bytes_field(2, encoded.Bytes())
+	}
+
+	profile.Write(location_table.Bytes())
+	profile.Write(function_table.Bytes())
+	for _, s := range string_table {
+		profile.bytes_field(6, []byte(s))
+	}
+	profile.uint64_field(9, uint64(profiler.started.UnixNano()))
+	profile.uint64_field(10, uint64(profiler.stopped.Sub(profiler.started).Nanoseconds()))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+	if _, err := writer.Write(profile.Bytes()); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// Just enough of the protobuf wire format for write_pprof.
+type proto_buffer struct {
+	bytes.Buffer
+}
+
+func (buffer *proto_buffer) varint(x uint64) {
+	for x >= 0x80 {
+		buffer.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	buffer.WriteByte(byte(x))
+}
+
+func (buffer *proto_buffer) uint64_field(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	buffer.varint(uint64(field) << 3)
+	buffer.varint(x)
+}
+
+func (buffer *proto_buffer) bytes_field(field int, data []byte) {
+	buffer.varint(uint64(field)<<3 | 2)
+	buffer.varint(uint64(len(data)))
+	buffer.Write(data)
+}
+
+func (buffer *proto_buffer) packed_field(field int, xs []uint64) {
+	var packed proto_buffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	buffer.bytes_field(field, packed.Bytes())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Gives back the instructions of every row of the table with the title, by
+// the name of the row.
+func profile_table(report string, title string) map[string]string {
+	rows := make(map[string]string)
+
+	_, table, found := strings.Cut(report, "\n"+title+" ")
+	if !found {
+		return rows
+	}
+	table, _, _ = strings.Cut(table, "\n\n")
+
+	for _, line := range strings.Split(table, "\n")[1:] {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			rows[fields[0]] = fields[1]
+		}
+	}
+	return rows
+}
+
+func TestProfileReport(t *testing.T) {
+	register_natives_once.Do(register_natives)
+
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	interpreter.stdout = &bytes.Buffer{}
+	interpreter.profiler = new_Profiler()
+	path := write_program(t, traced_program)
+	if result := interpreter.run_file(path); result != INTERPRETER_RESULT_OK {
+		t.Fatalf("The program failed with %v.", interpreter.err)
+	}
+
+	var report bytes.Buffer
+	interpreter.profiler.write_report(&report)
+
+	functions := profile_table(report.String(), "Function")
+	if functions["twice"] != "6" || functions["script"] != "6" {
+		t.Errorf("Expected twice and the script to each run 6 instructions, the report has:\n%s", report.String())
+	}
+
+	// The code the compiler adds around the script isn't on a line of it.
+	lines := profile_table(report.String(), "Line")
+	want := map[string]string{path + ":1": "7", path + ":2": "3", path + ":3": "2"}
+	if len(lines) != len(want) {
+		t.Errorf("Expected %d lines in the report, it has:\n%s", len(want), report.String())
+	}
+	for line, instructions := range want {
+		if lines[line] != instructions {
+			t.Errorf("Expected %s to run %s instructions, the report has:\n%s", line, instructions, report.String())
+		}
+	}
+
+	if opcodes := profile_table(report.String(), "Opcode"); opcodes["OP_MUL"] != "1" || opcodes["OP_EOF"] != "" {
+		t.Errorf("Expected one OP_MUL and no OP_EOF, the report has:\n%s", report.String())
+	}
+}
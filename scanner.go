@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Token_Type byte
@@ -15,6 +18,8 @@ const (
 
 	TOKEN_PRINT
 	TOKEN_PRINTLN
+	TOKEN_EPRINTLN
+	TOKEN_READ_LINE
 	TOKEN_VAR
 	TOKEN_ASSIGN
 	TOKEN_TRUE
@@ -29,6 +34,12 @@ const (
 	TOKEN_BREAK
 	TOKEN_FUNC
 	TOKEN_RETURN
+	TOKEN_IMPORT
+	TOKEN_AS
+	TOKEN_TRY
+	TOKEN_CATCH
+	TOKEN_THROW
+	TOKEN_TEST
 
 	TOKEN_LEFT_PAREN
 	TOKEN_RIGHT_PAREN
@@ -38,10 +49,17 @@ const (
 	TOKEN_RIGHT_BRACKET
 	TOKEN_COMMA
 	TOKEN_DOT
+	TOKEN_ELLIPSIS
 	TOKEN_MINUS
 	TOKEN_PLUS
 	TOKEN_STAR
+	TOKEN_STAR_STAR
 	TOKEN_SLASH
+	TOKEN_DIV
+	TOKEN_PERCENT
+	TOKEN_AMPERSAND
+	TOKEN_PIPE
+	TOKEN_CARET
 	TOKEN_SEMICOLON
 
 	TOKEN_COLON
@@ -54,15 +72,22 @@ const (
 	TOKEN_GREATER_EQUAL
 	TOKEN_LESS
 	TOKEN_LESS_EQUAL
+	TOKEN_LESS_LESS
+	TOKEN_GREATER_GREATER
 
 	TOKEN_TYPE_STRING
 	TOKEN_TYPE_INT
 	TOKEN_TYPE_DECIMAL
 	TOKEN_TYPE_UINT
 	TOKEN_TYPE_BOOL
+	TOKEN_TYPE_ANY
+	TOKEN_IS
 
 	// Literal
 	TOKEN_STRING
+	TOKEN_INTERPOLATION
+	TOKEN_INTERPOLATION_MIDDLE
+	TOKEN_INTERPOLATION_END
 	TOKEN_INT
 	TOKEN_DECIMAL
 	TOKEN_UINT
@@ -70,127 +95,321 @@ const (
 	TOKEN_IDENTIFER
 )
 
-type Scanner struct {
+var token_type_names = [...]string{
+	TOKEN_NONE:                 "TOKEN_NONE",
+	TOKEN_EOF:                  "TOKEN_EOF",
+	TOKEN_ERROR:                "TOKEN_ERROR",
+	TOKEN_PRINT:                "TOKEN_PRINT",
+	TOKEN_PRINTLN:              "TOKEN_PRINTLN",
+	TOKEN_EPRINTLN:             "TOKEN_EPRINTLN",
+	TOKEN_READ_LINE:            "TOKEN_READ_LINE",
+	TOKEN_VAR:                  "TOKEN_VAR",
+	TOKEN_ASSIGN:               "TOKEN_ASSIGN",
+	TOKEN_TRUE:                 "TOKEN_TRUE",
+	TOKEN_FALSE:                "TOKEN_FALSE",
+	TOKEN_IF:                   "TOKEN_IF",
+	TOKEN_ELSE:                 "TOKEN_ELSE",
+	TOKEN_AND:                  "TOKEN_AND",
+	TOKEN_OR:                   "TOKEN_OR",
+	TOKEN_SWITCH:               "TOKEN_SWITCH",
+	TOKEN_FOR:                  "TOKEN_FOR",
+	TOKEN_WHILE:                "TOKEN_WHILE",
+	TOKEN_BREAK:                "TOKEN_BREAK",
+	TOKEN_FUNC:                 "TOKEN_FUNC",
+	TOKEN_RETURN:               "TOKEN_RETURN",
+	TOKEN_IMPORT:               "TOKEN_IMPORT",
+	TOKEN_AS:                   "TOKEN_AS",
+	TOKEN_TRY:                  "TOKEN_TRY",
+	TOKEN_CATCH:                "TOKEN_CATCH",
+	TOKEN_THROW:                "TOKEN_THROW",
+	TOKEN_TEST:                 "TOKEN_TEST",
+	TOKEN_LEFT_PAREN:           "TOKEN_LEFT_PAREN",
+	TOKEN_RIGHT_PAREN:          "TOKEN_RIGHT_PAREN",
+	TOKEN_LEFT_BRACE:           "TOKEN_LEFT_BRACE",
+	TOKEN_RIGHT_BRACE:          "TOKEN_RIGHT_BRACE",
+	TOKEN_LEFT_BRACKET:         "TOKEN_LEFT_BRACKET",
+	TOKEN_RIGHT_BRACKET:        "TOKEN_RIGHT_BRACKET",
+	TOKEN_COMMA:                "TOKEN_COMMA",
+	TOKEN_DOT:                  "TOKEN_DOT",
+	TOKEN_ELLIPSIS:             "TOKEN_ELLIPSIS",
+	TOKEN_MINUS:                "TOKEN_MINUS",
+	TOKEN_PLUS:                 "TOKEN_PLUS",
+	TOKEN_STAR:                 "TOKEN_STAR",
+	TOKEN_STAR_STAR:            "TOKEN_STAR_STAR",
+	TOKEN_SLASH:                "TOKEN_SLASH",
+	TOKEN_DIV:                  "TOKEN_DIV",
+	TOKEN_PERCENT:              "TOKEN_PERCENT",
+	TOKEN_AMPERSAND:            "TOKEN_AMPERSAND",
+	TOKEN_PIPE:                 "TOKEN_PIPE",
+	TOKEN_CARET:                "TOKEN_CARET",
+	TOKEN_SEMICOLON:            "TOKEN_SEMICOLON",
+	TOKEN_COLON:                "TOKEN_COLON",
+	TOKEN_COLON_EQUAL:          "TOKEN_COLON_EQUAL",
+	TOKEN_EQUAL:                "TOKEN_EQUAL",
+	TOKEN_EQUAL_EQUAL:          "TOKEN_EQUAL_EQUAL",
+	TOKEN_NOT:                  "TOKEN_NOT",
+	TOKEN_NOT_EQUAL:            "TOKEN_NOT_EQUAL",
+	TOKEN_GREATER:              "TOKEN_GREATER",
+	TOKEN_GREATER_EQUAL:        "TOKEN_GREATER_EQUAL",
+	TOKEN_LESS:                 "TOKEN_LESS",
+	TOKEN_LESS_EQUAL:           "TOKEN_LESS_EQUAL",
+	TOKEN_LESS_LESS:            "TOKEN_LESS_LESS",
+	TOKEN_GREATER_GREATER:      "TOKEN_GREATER_GREATER",
+	TOKEN_TYPE_STRING:          "TOKEN_TYPE_STRING",
+	TOKEN_TYPE_INT:             "TOKEN_TYPE_INT",
+	TOKEN_TYPE_DECIMAL:         "TOKEN_TYPE_DECIMAL",
+	TOKEN_TYPE_UINT:            "TOKEN_TYPE_UINT",
+	TOKEN_TYPE_BOOL:            "TOKEN_TYPE_BOOL",
+	TOKEN_TYPE_ANY:             "TOKEN_TYPE_ANY",
+	TOKEN_IS:                   "TOKEN_IS",
+	TOKEN_STRING:               "TOKEN_STRING",
+	TOKEN_INTERPOLATION:        "TOKEN_INTERPOLATION",
+	TOKEN_INTERPOLATION_MIDDLE: "TOKEN_INTERPOLATION_MIDDLE",
+	TOKEN_INTERPOLATION_END:    "TOKEN_INTERPOLATION_END",
+	TOKEN_INT:                  "TOKEN_INT",
+	TOKEN_DECIMAL:              "TOKEN_DECIMAL",
+	TOKEN_UINT:                 "TOKEN_UINT",
+	TOKEN_BOOL:                 "TOKEN_BOOL",
+	TOKEN_IDENTIFER:            "TOKEN_IDENTIFER",
+}
+
+func (t Token_Type) String() string {
+	if int(t) < len(token_type_names) {
+		return token_type_names[t]
+	}
+	return fmt.Sprintf("Token_Type(%d)", byte(t))
+}
+
+// Turns source code into tokens, one at a time through Next. Each CodeGen has
+// its own, but it can also be used on its own by tooling.
+type Lexer struct {
 	chars   []byte
 	current uint
 	start   uint
 	line    uint
+
+	// Columns are counted from the start of the last token on the line, which
+	// is at 'column_offset' and in column 'column', so long lines don't get
+	// counted again for every token.
+	column_offset uint
+	column        uint
+	start_line    uint
+	start_column  uint
+
+	// How many '{' are open in each '${' that is being scanned, the
+	// innermost interpolation is last.
+	interpolations []int
 }
 
 type Token struct {
 	t_type Token_Type
 	lexeme string
 	line   uint
+	column uint
 }
 
-var scanner Scanner
-
 func is_Token_of_type(token Token, t_type Token_Type) bool {
 	return token.t_type == t_type
 }
 
-func make_Token(t_type Token_Type) Token {
-	var token = Token{}
-	token.t_type = t_type
-	token.lexeme = string(scanner.chars[scanner.start:scanner.current])
-	token.line = scanner.line
-	return token
+func (lexer *Lexer) make_Token(t_type Token_Type) Token {
+	return lexer.make_Token_len(t_type, lexer.start, lexer.current)
+}
+
+func (lexer *Lexer) make_Token_len(t_type Token_Type, start uint, current uint) Token {
+	return lexer.make_Token_lexeme(t_type, string(lexer.chars[start:current]))
 }
 
-func make_Token_len(t_type Token_Type, start uint, current uint) Token {
+func (lexer *Lexer) make_Token_lexeme(t_type Token_Type, lexeme string) Token {
 	var token = Token{}
 	token.t_type = t_type
-	token.lexeme = string(scanner.chars[start:current])
-	token.line = scanner.line
+	token.lexeme = lexeme
+	token.line = lexer.start_line
+	token.column = lexer.start_column
 	return token
 }
 
-func error_token(msg string) Token {
-	var token = Token{}
-	token.t_type = TOKEN_ERROR
-	token.lexeme = msg
-	token.line = scanner.line
-	return token
+func (lexer *Lexer) error_token(msg string) Token {
+	return lexer.make_Token_lexeme(TOKEN_ERROR, msg)
+}
+
+// Remembers where the token being scanned starts.
+func (lexer *Lexer) mark_start() {
+	lexer.start = lexer.current
+	lexer.start_line = lexer.line
+	lexer.column += uint(utf8.RuneCount(lexer.chars[lexer.column_offset:lexer.current]))
+	lexer.column_offset = lexer.current
+	lexer.start_column = lexer.column + 1
+}
+
+// Has to be called after advancing past a '\n'.
+func (lexer *Lexer) newline() {
+	lexer.line++
+	lexer.column_offset = lexer.current
+	lexer.column = 0
 }
 
-func is_at_end() bool {
-	if len(scanner.chars) <= int(scanner.current) {
+func (lexer *Lexer) is_at_end() bool {
+	if len(lexer.chars) <= int(lexer.current) {
 		return true
 	}
 
-	return scanner.chars[scanner.current] == '\000'
+	return lexer.chars[lexer.current] == '\000'
 }
 
-func advance() (result byte) {
-	result = peek()
-	scanner.current++
+func (lexer *Lexer) advance() (result byte) {
+	result = lexer.peek()
+	lexer.current++
 	return
 }
 
-func new_Scanner(file_path string) {
+func new_Lexer(source []byte) *Lexer {
+	lexer := &Lexer{}
+	lexer.chars = append(source[0:len(source):len(source)], '\000')
+	lexer.current = 0
+	lexer.start = 0
+	lexer.line = 1
+
+	// Skips a '#!' line, so scripts can be run directly. The newline is left for
+	// skip_whitespace to count.
+	if len(source) >= 2 && source[0] == '#' && source[1] == '!' {
+		for lexer.peek() != '\n' && !lexer.is_at_end() {
+			lexer.advance()
+		}
+	}
+	return lexer
+}
+
+func new_Lexer_from_file(file_path string) (*Lexer, error) {
 	chars, err := os.ReadFile(file_path)
 	if err != nil {
-		log.Panic("custom", err.Error())
+		return nil, err
 	}
 
-	scanner.chars = append(chars, '\000')
-	scanner.current = 0
-	scanner.start = 0
-	scanner.line = 1
-	return
+	return new_Lexer(chars), nil
+}
+
+// Scans the next token, after the end of the source this keeps on giving back
+// TOKEN_EOF.
+func (lexer *Lexer) Next() Token {
+	return lexer.scan_token()
 }
 
-func match(expected byte) bool {
-	if is_at_end() {
+// Scans every token that is left, the last one is always TOKEN_EOF.
+func (lexer *Lexer) Tokens() []Token {
+	var tokens []Token
+	for {
+		token := lexer.Next()
+		tokens = append(tokens, token)
+		if token.t_type == TOKEN_EOF {
+			return tokens
+		}
+	}
+}
+
+func (lexer *Lexer) match(expected byte) bool {
+	if lexer.is_at_end() {
 		return false
 	}
-	if scanner.chars[scanner.current] != expected {
+	if lexer.chars[lexer.current] != expected {
 		return false
 	}
-	scanner.current++
+	lexer.current++
 	return true
 }
 
-func peek() byte {
-	if is_at_end() {
+func (lexer *Lexer) peek() byte {
+	if lexer.is_at_end() {
 		return '\000'
 	}
 
-	return scanner.chars[scanner.current]
+	return lexer.chars[lexer.current]
 }
 
-func peek_next() byte {
-	if is_at_end() {
+func (lexer *Lexer) peek_next() byte {
+	return lexer.peek_offset(1)
+}
+
+func (lexer *Lexer) peek_offset(offset uint) byte {
+	if lexer.is_at_end() || int(lexer.current+offset) >= len(lexer.chars) {
 		return '\000'
 	}
-	return scanner.chars[scanner.current+1]
+	return lexer.chars[lexer.current+offset]
 }
 
-func skip_whitespace() {
+// Skips whitespace and comments, giving back an error message if a block
+// comment is never closed.
+func (lexer *Lexer) skip_whitespace() string {
 	for {
-		var c = peek()
+		var c = lexer.peek()
 
 		switch c {
 		case ' ', '\r', '\t':
-			advance()
+			lexer.advance()
 
 		case '\n':
-			scanner.line++
-			advance()
+			lexer.advance()
+			lexer.newline()
 
 		case '/':
-			if peek_next() == '/' {
-				for peek() != '\n' && !is_at_end() {
-					advance()
+			if lexer.peek_next() == '/' {
+				for lexer.peek() != '\n' && !lexer.is_at_end() {
+					lexer.advance()
+				}
+			} else if lexer.peek_next() == '*' {
+				if !lexer.skip_block_comment() {
+					return "Unterminated block comment."
 				}
 			} else {
-				return
+				return ""
+			}
+
+		default:
+			return ""
+		}
+	}
+}
+
+// Block comments can be nested, so /* a /* b */ c */ is a single comment.
+func (lexer *Lexer) skip_block_comment() bool {
+	depth := 0
+	lexer.mark_start()
+
+	for !lexer.is_at_end() {
+		if lexer.peek() == '/' && lexer.peek_next() == '*' {
+			lexer.advance()
+			lexer.advance()
+			depth++
+		} else if lexer.peek() == '*' && lexer.peek_next() == '/' {
+			lexer.advance()
+			lexer.advance()
+			depth--
+			if depth == 0 {
+				return true
 			}
+		} else if lexer.advance() == '\n' {
+			lexer.newline()
+		}
+	}
 
+	return false
+}
+
+// Whether the character at the position is the first thing in a list. This is
+// how '-5' is told apart from subtracting 5.
+func (lexer *Lexer) is_list_operator(position uint) bool {
+	for i := int(position) - 1; i >= 0; i-- {
+		switch lexer.chars[i] {
+		case ' ', '\t':
+			continue
+		case '(':
+			return true
 		default:
-			return
+			return false
 		}
 	}
+
+	return false
 }
 
 func is_digit(c byte) bool {
@@ -203,188 +422,468 @@ func is_alpha(c byte) bool {
 		c == '_'
 }
 
-func string_Token() Token {
-	for peek() != '"' && !is_at_end() {
-		if peek() == '\n' {
-			scanner.line++
+// Identifiers can have '-' and '?' after the first character, like 'type-of' and
+// 'is?'. So 'a-b' is one identifier and not a minus b, that needs '(- a b)'.
+func is_identifer_char(c byte) bool {
+	return is_alpha(c) || is_digit(c) || c == '-' || c == '?'
+}
+
+// The scanner works on bytes, anything outside of ASCII gets decoded from UTF-8
+// so identifiers can use letters from any language.
+func (lexer *Lexer) peek_rune() (rune, int) {
+	if lexer.is_at_end() {
+		return utf8.RuneError, 0
+	}
+
+	return utf8.DecodeRune(lexer.chars[lexer.current:])
+}
+
+func is_unicode_identifer_char(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)
+}
+
+// Scans the rest of a string, after the opening '"' or the '}' that closed an
+// interpolation. A string with interpolations like "a ${b} c ${d} e" gets split
+// up into TOKEN_INTERPOLATION("a "), the tokens of b, TOKEN_INTERPOLATION_MIDDLE(" c "),
+// the tokens of d and then TOKEN_INTERPOLATION_END(" e").
+func (lexer *Lexer) string_Token(resumed bool) Token {
+	var builder strings.Builder
+
+	for lexer.peek() != '"' && !lexer.is_at_end() {
+		c := lexer.advance()
+
+		switch c {
+		case '\n':
+			lexer.newline()
+			builder.WriteByte(c)
+
+		case '\\':
+			if msg := lexer.escape_sequence(&builder); msg != "" {
+				return lexer.error_token(msg)
+			}
+
+		case '$':
+			if lexer.match('{') {
+				lexer.interpolations = append(lexer.interpolations, 0)
+				if resumed {
+					return lexer.make_Token_lexeme(TOKEN_INTERPOLATION_MIDDLE, builder.String())
+				}
+				return lexer.make_Token_lexeme(TOKEN_INTERPOLATION, builder.String())
+			}
+			builder.WriteByte(c)
+
+		default:
+			builder.WriteByte(c)
 		}
-		advance()
 	}
 
-	if is_at_end() {
-		return error_token("Unterminated string.")
+	if lexer.is_at_end() {
+		return lexer.error_token("Unterminated string.")
 	}
 
-	advance()
+	lexer.advance()
 
-	return make_Token_len(TOKEN_STRING, scanner.start+1, scanner.current-1)
+	if resumed {
+		return lexer.make_Token_lexeme(TOKEN_INTERPOLATION_END, builder.String())
+	}
+	return lexer.make_Token_lexeme(TOKEN_STRING, builder.String())
 }
 
-func number_Token() Token {
-	for is_digit(peek()) {
-		advance()
+// Writes out the escape sequence after a '\\', giving back an error message if
+// it isn't a valid one.
+func (lexer *Lexer) escape_sequence(builder *strings.Builder) string {
+	if lexer.is_at_end() {
+		return "Unterminated string."
 	}
 
-	if peek() == '.' && is_digit(peek_next()) {
-		advance()
+	switch c := lexer.advance(); c {
+	case 'n':
+		builder.WriteByte('\n')
+	case 't':
+		builder.WriteByte('\t')
+	case 'r':
+		builder.WriteByte('\r')
+	case '0':
+		builder.WriteByte(0)
+	case '\\', '"', '$', '`':
+		builder.WriteByte(c)
+
+	case 'u':
+		if !lexer.match('{') {
+			return "Expected '{' after '\\u'."
+		}
+
+		start := lexer.current
+		for lexer.peek() != '}' && lexer.peek() != '"' && !lexer.is_at_end() {
+			lexer.advance()
+		}
 
-		for is_digit(peek()) {
-			advance()
+		code_point, err := strconv.ParseUint(string(lexer.chars[start:lexer.current]), 16, 32)
+		if !lexer.match('}') || err != nil || !utf8.ValidRune(rune(code_point)) {
+			return "Invalid unicode escape sequence."
 		}
+		builder.WriteRune(rune(code_point))
 
-		return make_Token(TOKEN_DECIMAL)
-	} else if peek() == 'u' {
-		advance()
-		return make_Token_len(TOKEN_UINT, scanner.start, scanner.current-1)
+	default:
+		return fmt.Sprintf("Unknown escape sequence '\\%c'.", c)
 	}
 
-	return make_Token(TOKEN_INT)
+	return ""
 }
 
-func identifer_Token() Token {
-	for is_alpha(peek()) || is_digit(peek()) {
-		advance()
+// Raw strings don't have escape sequences or interpolation, so they are useful
+// for text that spans multiple lines.
+func (lexer *Lexer) raw_string_Token() Token {
+	for lexer.peek() != '`' && !lexer.is_at_end() {
+		if lexer.advance() == '\n' {
+			lexer.newline()
+		}
+	}
+
+	if lexer.is_at_end() {
+		return lexer.error_token("Unterminated raw string.")
+	}
+
+	lexer.advance()
+
+	return lexer.make_Token_len(TOKEN_STRING, lexer.start+1, lexer.current-1)
+}
+
+func is_base_digit(c byte, base int) bool {
+	switch base {
+	case 2:
+		return c == '0' || c == '1'
+	case 8:
+		return c >= '0' && c <= '7'
+	case 16:
+		return is_digit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+	}
+
+	return is_digit(c)
+}
+
+// Reads the digits of a number, which can be separated by single underscores
+// like 1_000_000. Gives back an error message if an underscore is misplaced.
+func (lexer *Lexer) number_digits(base int) string {
+	for {
+		if is_base_digit(lexer.peek(), base) {
+			lexer.advance()
+		} else if lexer.peek() == '_' {
+			if !is_base_digit(lexer.peek_next(), base) {
+				return "Underscores in numbers have to be between digits."
+			}
+			lexer.advance()
+		} else {
+			return ""
+		}
+	}
+}
+
+// Numbers can be written like 42, -42, 42u, 0xff, 0b1010, 0o755, 1_000_000,
+// 4.2 or 4.2e-3. The first character, a digit or '-', has already been read.
+func (lexer *Lexer) number_Token() Token {
+	first := lexer.chars[lexer.start]
+	negative := first == '-'
+	if negative {
+		first = lexer.advance()
+	}
+
+	if first == '0' && (lexer.peek() == 'x' || lexer.peek() == 'b' || lexer.peek() == 'o') {
+		prefix := lexer.advance()
+		base := map[byte]int{'x': 16, 'b': 2, 'o': 8}[prefix]
+
+		if !is_base_digit(lexer.peek(), base) {
+			return lexer.error_token(fmt.Sprintf("Expected digits after '0%c'.", prefix))
+		}
+		if msg := lexer.number_digits(base); msg != "" {
+			return lexer.error_token(msg)
+		}
+		if is_digit(lexer.peek()) || (is_alpha(lexer.peek()) && lexer.peek() != 'u') {
+			return lexer.error_token(fmt.Sprintf("Invalid digit '%c' in a base %d number.", lexer.peek(), base))
+		}
+
+		return lexer.integer_Token(negative)
 	}
 
-	switch string(scanner.chars[scanner.start:scanner.current]) {
+	if msg := lexer.number_digits(10); msg != "" {
+		return lexer.error_token(msg)
+	}
+
+	is_decimal := false
+
+	if lexer.peek() == '.' && is_digit(lexer.peek_next()) {
+		lexer.advance()
+		if msg := lexer.number_digits(10); msg != "" {
+			return lexer.error_token(msg)
+		}
+		is_decimal = true
+	}
+
+	if (lexer.peek() == 'e' || lexer.peek() == 'E') &&
+		(is_digit(lexer.peek_next()) || ((lexer.peek_next() == '+' || lexer.peek_next() == '-') && is_digit(lexer.peek_offset(2)))) {
+		lexer.advance()
+		if !lexer.match('+') {
+			lexer.match('-')
+		}
+		if msg := lexer.number_digits(10); msg != "" {
+			return lexer.error_token(msg)
+		}
+		is_decimal = true
+	}
+
+	if is_decimal {
+		return lexer.make_Token(TOKEN_DECIMAL)
+	}
+
+	return lexer.integer_Token(negative)
+}
+
+func (lexer *Lexer) integer_Token(negative bool) Token {
+	if lexer.peek() == 'u' {
+		lexer.advance()
+		if negative {
+			return lexer.error_token("A uint can't be negative.")
+		}
+		return lexer.make_Token_len(TOKEN_UINT, lexer.start, lexer.current-1)
+	}
+
+	return lexer.make_Token(TOKEN_INT)
+}
+
+func (lexer *Lexer) identifer_Token() Token {
+	for {
+		if is_identifer_char(lexer.peek()) {
+			lexer.advance()
+		} else if lexer.peek() == '.' && is_alpha(lexer.peek_next()) {
+			// Functions of a module are called as 'alias.name'.
+			lexer.advance()
+		} else if lexer.peek() >= utf8.RuneSelf {
+			r, size := lexer.peek_rune()
+			if r == utf8.RuneError || !is_unicode_identifer_char(r) {
+				break
+			}
+			lexer.current += uint(size)
+		} else {
+			break
+		}
+	}
+
+	switch string(lexer.chars[lexer.start:lexer.current]) {
 	case "print":
-		return make_Token(TOKEN_PRINT)
+		return lexer.make_Token(TOKEN_PRINT)
 	case "println":
-		return make_Token(TOKEN_PRINTLN)
+		return lexer.make_Token(TOKEN_PRINTLN)
+	case "eprintln":
+		return lexer.make_Token(TOKEN_EPRINTLN)
+	case "read-line":
+		return lexer.make_Token(TOKEN_READ_LINE)
 	case "var":
-		return make_Token(TOKEN_VAR)
+		return lexer.make_Token(TOKEN_VAR)
 	case "true":
-		return make_Token(TOKEN_TRUE)
+		return lexer.make_Token(TOKEN_TRUE)
 	case "false":
-		return make_Token(TOKEN_FALSE)
+		return lexer.make_Token(TOKEN_FALSE)
 	case "if":
-		return make_Token(TOKEN_IF)
+		return lexer.make_Token(TOKEN_IF)
 	case "else":
-		return make_Token(TOKEN_ELSE)
+		return lexer.make_Token(TOKEN_ELSE)
 	case "and":
-		return make_Token(TOKEN_AND)
+		return lexer.make_Token(TOKEN_AND)
 	case "or":
-		return make_Token(TOKEN_OR)
+		return lexer.make_Token(TOKEN_OR)
 	case "switch":
-		return make_Token(TOKEN_SWITCH)
+		return lexer.make_Token(TOKEN_SWITCH)
 	case "for":
-		return make_Token(TOKEN_FOR)
+		return lexer.make_Token(TOKEN_FOR)
 	case "while":
-		return make_Token(TOKEN_WHILE)
+		return lexer.make_Token(TOKEN_WHILE)
 	case "break":
-		return make_Token(TOKEN_BREAK)
+		return lexer.make_Token(TOKEN_BREAK)
 	case "func":
-		return make_Token(TOKEN_FUNC)
+		return lexer.make_Token(TOKEN_FUNC)
 	case "return":
-		return make_Token(TOKEN_RETURN)
+		return lexer.make_Token(TOKEN_RETURN)
+	case "import":
+		return lexer.make_Token(TOKEN_IMPORT)
+	case "as":
+		return lexer.make_Token(TOKEN_AS)
+	case "try":
+		return lexer.make_Token(TOKEN_TRY)
+	case "catch":
+		return lexer.make_Token(TOKEN_CATCH)
+	case "throw", "error":
+		return lexer.make_Token(TOKEN_THROW)
+	case "test":
+		return lexer.make_Token(TOKEN_TEST)
 
 	case "assign":
-		return make_Token(TOKEN_ASSIGN)
+		return lexer.make_Token(TOKEN_ASSIGN)
+
+	// Floor division, '//' would be a comment.
+	case "div":
+		return lexer.make_Token(TOKEN_DIV)
 
 	case "int":
-		return make_Token(TOKEN_TYPE_INT)
+		return lexer.make_Token(TOKEN_TYPE_INT)
 
 	case "uint":
-		return make_Token(TOKEN_TYPE_UINT)
+		return lexer.make_Token(TOKEN_TYPE_UINT)
 
 	case "bool":
-		return make_Token(TOKEN_TYPE_BOOL)
+		return lexer.make_Token(TOKEN_TYPE_BOOL)
 
 	case "decimal":
-		return make_Token(TOKEN_TYPE_DECIMAL)
+		return lexer.make_Token(TOKEN_TYPE_DECIMAL)
 
 	case "string":
-		return make_Token(TOKEN_TYPE_STRING)
+		return lexer.make_Token(TOKEN_TYPE_STRING)
+
+	case "any":
+		return lexer.make_Token(TOKEN_TYPE_ANY)
+
+	case "is?":
+		return lexer.make_Token(TOKEN_IS)
 	}
 
-	return make_Token(TOKEN_IDENTIFER)
+	return lexer.make_Token(TOKEN_IDENTIFER)
 }
 
-func scan_token() Token {
-	skip_whitespace()
-	scanner.start = scanner.current
+func (lexer *Lexer) scan_token() Token {
+	if msg := lexer.skip_whitespace(); msg != "" {
+		return lexer.error_token(msg)
+	}
+	lexer.mark_start()
 
-	if is_at_end() {
-		return make_Token(TOKEN_EOF)
+	if lexer.is_at_end() {
+		return lexer.make_Token(TOKEN_EOF)
 	}
 
-	var c = advance()
+	var c = lexer.advance()
 
 	if is_digit(c) {
-		return number_Token()
+		return lexer.number_Token()
 	}
 
 	if is_alpha(c) {
-		return identifer_Token()
+		return lexer.identifer_Token()
+	}
+
+	if c >= utf8.RuneSelf {
+		lexer.current = lexer.start
+		r, size := lexer.peek_rune()
+		lexer.current += uint(size)
+
+		if r == utf8.RuneError && size <= 1 {
+			return lexer.error_token("Invalid UTF-8 encoding.")
+		} else if unicode.IsLetter(r) {
+			return lexer.identifer_Token()
+		}
+
+		return lexer.error_token(fmt.Sprintf("Unexpected character '%c'.", r))
 	}
 
 	switch c {
 	case '(':
-		return make_Token(TOKEN_LEFT_PAREN)
+		return lexer.make_Token(TOKEN_LEFT_PAREN)
 	case ')':
-		return make_Token(TOKEN_RIGHT_PAREN)
+		return lexer.make_Token(TOKEN_RIGHT_PAREN)
 	case '{':
-		return make_Token(TOKEN_LEFT_BRACE)
+		if len(lexer.interpolations) > 0 {
+			lexer.interpolations[len(lexer.interpolations)-1]++
+		}
+		return lexer.make_Token(TOKEN_LEFT_BRACE)
 	case '}':
-		return make_Token(TOKEN_RIGHT_BRACE)
+		if depth := len(lexer.interpolations); depth > 0 {
+			if lexer.interpolations[depth-1] == 0 {
+				lexer.interpolations = lexer.interpolations[0 : depth-1]
+				return lexer.string_Token(true)
+			}
+			lexer.interpolations[depth-1]--
+		}
+		return lexer.make_Token(TOKEN_RIGHT_BRACE)
 	case '[':
-		return make_Token(TOKEN_LEFT_BRACKET)
+		return lexer.make_Token(TOKEN_LEFT_BRACKET)
 	case ']':
-		return make_Token(TOKEN_RIGHT_BRACKET)
+		return lexer.make_Token(TOKEN_RIGHT_BRACKET)
 	case ';':
-		return make_Token(TOKEN_SEMICOLON)
+		return lexer.make_Token(TOKEN_SEMICOLON)
 	case ',':
-		return make_Token(TOKEN_COMMA)
+		return lexer.make_Token(TOKEN_COMMA)
 	case '.':
-		return make_Token(TOKEN_DOT)
+		if lexer.peek() == '.' && lexer.peek_next() == '.' {
+			lexer.advance()
+			lexer.advance()
+			return lexer.make_Token(TOKEN_ELLIPSIS)
+		}
+		return lexer.make_Token(TOKEN_DOT)
 	case '-':
-		return make_Token(TOKEN_MINUS)
+		if is_digit(lexer.peek()) && !lexer.is_list_operator(lexer.start) {
+			return lexer.number_Token()
+		}
+		return lexer.make_Token(TOKEN_MINUS)
 	case '+':
-		return make_Token(TOKEN_PLUS)
+		return lexer.make_Token(TOKEN_PLUS)
 	case '*':
-		return make_Token(TOKEN_STAR)
+		if lexer.match('*') {
+			return lexer.make_Token(TOKEN_STAR_STAR)
+		}
+		return lexer.make_Token(TOKEN_STAR)
 	case '/':
-		return make_Token(TOKEN_SLASH)
+		return lexer.make_Token(TOKEN_SLASH)
+	case '%':
+		return lexer.make_Token(TOKEN_PERCENT)
+	case '&':
+		return lexer.make_Token(TOKEN_AMPERSAND)
+	case '|':
+		return lexer.make_Token(TOKEN_PIPE)
+	case '^':
+		return lexer.make_Token(TOKEN_CARET)
 
 	case '!':
-		if match('=') {
-			return make_Token(TOKEN_NOT_EQUAL)
+		if lexer.match('=') {
+			return lexer.make_Token(TOKEN_NOT_EQUAL)
 		} else {
-			return make_Token(TOKEN_NOT)
+			return lexer.make_Token(TOKEN_NOT)
 		}
 
 	case '=':
-		if match('=') {
-			return make_Token(TOKEN_EQUAL_EQUAL)
+		if lexer.match('=') {
+			return lexer.make_Token(TOKEN_EQUAL_EQUAL)
 		} else {
-			return make_Token(TOKEN_EQUAL)
+			return lexer.make_Token(TOKEN_EQUAL)
 		}
 
 	case '>':
-		if match('=') {
-			return make_Token(TOKEN_GREATER_EQUAL)
+		if lexer.match('=') {
+			return lexer.make_Token(TOKEN_GREATER_EQUAL)
+		} else if lexer.match('>') {
+			return lexer.make_Token(TOKEN_GREATER_GREATER)
 		} else {
-			return make_Token(TOKEN_GREATER)
+			return lexer.make_Token(TOKEN_GREATER)
 		}
 
 	case '<':
-		if match('=') {
-			return make_Token(TOKEN_LESS_EQUAL)
+		if lexer.match('=') {
+			return lexer.make_Token(TOKEN_LESS_EQUAL)
+		} else if lexer.match('<') {
+			return lexer.make_Token(TOKEN_LESS_LESS)
 		} else {
-			return make_Token(TOKEN_LESS)
+			return lexer.make_Token(TOKEN_LESS)
 		}
 
 	case ':':
-		if match('=') {
-			return make_Token(TOKEN_COLON_EQUAL)
+		if lexer.match('=') {
+			return lexer.make_Token(TOKEN_COLON_EQUAL)
 		} else {
-			return make_Token(TOKEN_COLON)
+			return lexer.make_Token(TOKEN_COLON)
 		}
 
 	case '"':
-		return string_Token()
+		return lexer.string_Token(false)
+
+	case '`':
+		return lexer.raw_string_Token()
 	}
 
-	fmt.Printf("character that stopped at: '%c'\n", c)
-	return error_token("Unexpected character.")
+	return lexer.error_token(fmt.Sprintf("Unexpected character '%c'.", c))
 }
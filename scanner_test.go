@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+type scanned struct {
+	t_type Token_Type
+	lexeme string
+}
+
+func scan_all(source string) []scanned {
+	var tokens []scanned
+	for _, token := range new_Lexer([]byte(source)).Tokens() {
+		tokens = append(tokens, scanned{token.t_type, token.lexeme})
+	}
+	return tokens
+}
+
+// '-' and '?' are part of an identifier once it has started, so 'a-b' doesn't
+// get split up, while a '-' on its own is still the minus.
+func TestIdentifierCharacters(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []scanned
+	}{
+		{"a-b", []scanned{{TOKEN_IDENTIFER, "a-b"}}},
+		{"is?", []scanned{{TOKEN_IS, "is?"}}},
+		{"even?", []scanned{{TOKEN_IDENTIFER, "even?"}}},
+		{"type-of", []scanned{{TOKEN_IDENTIFER, "type-of"}}},
+		{"empty-list?", []scanned{{TOKEN_IDENTIFER, "empty-list?"}}},
+		{"(- a b)", []scanned{
+			{TOKEN_LEFT_PAREN, "("},
+			{TOKEN_MINUS, "-"},
+			{TOKEN_IDENTIFER, "a"},
+			{TOKEN_IDENTIFER, "b"},
+			{TOKEN_RIGHT_PAREN, ")"},
+		}},
+	}
+
+	for _, test := range tests {
+		got := scan_all(test.source)
+		if len(got) == 0 || got[len(got)-1].t_type != TOKEN_EOF {
+			t.Errorf("%q didn't end with TOKEN_EOF.", test.source)
+			continue
+		}
+
+		got = got[:len(got)-1]
+		if len(got) != len(test.want) {
+			t.Errorf("%q gave %v, expected %v.", test.source, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q gave %v, expected %v.", test.source, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A '(test "name" body)' of a script, 'function' is what it is called in the
+// ftable.
+type Test_Case struct {
+	name     string
+	function string
+	line     uint32
+}
+
+// Finds the *_test.tesp files in the paths, going into directories. Files that
+// are given directly are run whatever they are called.
+func find_test_files(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(file, "_test.tesp") {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Runs the code of the file outside of its tests first, then every test with a
+// copy of the variables that code defined. Gives back how many tests passed and failed, a file
+// that doesn't compile or fails outside of its tests counts as one failure.
+func (interpreter *Interpreter) run_tests(path string, output io.Writer) (passed int, failed int) {
+	// Every file has functions of its own, so they mustn't stay in the ftable.
+	natives := len(ftable.functions)
+	defer func() { ftable.functions = ftable.functions[:natives] }()
+
+	fmt.Fprintf(output, "=== %s\n", path)
+	start := time.Now()
+
+	gen := interpreter.new_CodeGen()
+	chunk := gen.generate_chunk(path)
+	if gen.had_error {
+		fmt.Fprintf(output, "FAIL  %s doesn't compile\n", path)
+		return 0, 1
+	}
+
+	vm := interpreter.new_VM(&chunk)
+	defer free_VM(&vm)
+
+	env = new_Environment()
+	if run(&vm) != INTERPRETER_RESULT_OK {
+		fmt.Fprintf(output, "FAIL  %s\n    %s\n", path, vm.err)
+		return 0, 1
+	}
+
+	// A test can change the variables, but the next one doesn't see that.
+	setup := snapshot_Environments()
+	for _, test := range gen.tests {
+		setup.restore()
+		vm.stack.values = vm.stack.values[:0]
+		vm.err = nil
+
+		test_start := time.Now()
+		result := run_function(&vm, test.function)
+		elapsed := time.Since(test_start)
+
+		if result == INTERPRETER_RESULT_OK {
+			passed++
+			fmt.Fprintf(output, "--- PASS: %s (%v)\n", test.name, elapsed)
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(output, "--- FAIL: %s (%v)\n", test.name, elapsed)
+
+		file := filepath.Base(path)
+		if len(vm.err.trace) > 0 {
+			file = vm.err.trace[0].file
+		}
+		fmt.Fprintf(output, "    %s:%d: %s\n", file, vm.err.line, vm.err.message)
+
+		// The limits stop everything, not just the test.
+		if result != INTERPRETER_RESULT_INTERPET_ERROR {
+			break
+		}
+	}
+
+	status := "ok  "
+	if failed > 0 {
+		status = "FAIL"
+	}
+	fmt.Fprintf(output, "%s  %s  %d passed, %d failed (%v)\n", status, path, passed, failed, time.Since(start))
+	return
+}
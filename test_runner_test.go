@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// The tests of a file get what its code outside of the tests defined, each
+// with a copy of its own.
+func TestRunTests(t *testing.T) {
+	register_natives_once.Do(register_natives)
+
+	var output bytes.Buffer
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	passed, failed := interpreter.run_tests(filepath.Join("testdata", "tests", "setup_test.tesp"), &output)
+	if passed != 3 || failed != 0 {
+		t.Errorf("%d passed and %d failed, expected 3 to pass:\n%s", passed, failed, output.String())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Writes every instruction the VM runs, either as text or as one JSON object a
+// line. The filters only let through the instructions of one function, or of a
+// range of lines.
+type Tracer struct {
+	writer *bufio.Writer
+	json   bool
+
+	// Empty means every function, "script" is the code outside of functions.
+	function string
+
+	// A last line of 0 means there is no end to the range.
+	first_line uint32
+	last_line  uint32
+}
+
+type Trace_Record struct {
+	Offset   uint32   `json:"offset"`
+	Opcode   string   `json:"opcode"`
+	Operands []string `json:"operands"`
+	File     string   `json:"file"`
+	Line     uint32   `json:"line"`
+	Function string   `json:"function"`
+	Stack    []string `json:"stack"`
+	Scope    uint8    `json:"scope"`
+}
+
+func new_Tracer(writer io.Writer, json bool) *Tracer {
+	return &Tracer{bufio.NewWriter(writer), json, "", 0, 0}
+}
+
+// Parses a line range like "10-20", "10-" or "10".
+func (tracer *Tracer) set_lines(lines string) error {
+	first, last, ranged := strings.Cut(lines, "-")
+
+	if _, err := fmt.Sscan(first, &tracer.first_line); err != nil {
+		return fmt.Errorf("'%s' isn't a line range", lines)
+	}
+
+	tracer.last_line = tracer.first_line
+	if ranged {
+		tracer.last_line = 0
+		if last != "" {
+			if _, err := fmt.Sscan(last, &tracer.last_line); err != nil || tracer.last_line < tracer.first_line {
+				return fmt.Errorf("'%s' isn't a line range", lines)
+			}
+		}
+	}
+	return nil
+}
+
+// The function the VM is running, "script" outside of functions.
+func (vm *VM) current_function() string {
+	if len(vm.function_names) == 0 {
+		return "script"
+	}
+	return vm.function_names[len(vm.function_names)-1]
+}
+
+// Called with vm.index at the instruction the VM is about to run.
+func (tracer *Tracer) trace(vm *VM) {
+	line := vm.chunk.lines[vm.index]
+	if line < tracer.first_line || (tracer.last_line != 0 && line > tracer.last_line) {
+		return
+	}
+
+	function := vm.current_function()
+	if tracer.function != "" && function != tracer.function {
+		return
+	}
+
+	instruction := decode_instruction(vm.chunk, uint64(vm.index))
+
+	stack := make([]string, len(vm.stack.values))
+	for i, v := range vm.stack.values {
+		stack[i] = v.String()
+	}
+
+	record := Trace_Record{vm.index, instruction.name, instruction.operands, vm.chunk.file_at(vm.index).name, line, function, stack, env.currentScope}
+	if record.Operands == nil {
+		record.Operands = []string{}
+	}
+
+	if tracer.json {
+		body, _ := json.Marshal(record)
+		tracer.writer.Write(body)
+		tracer.writer.WriteByte('\n')
+		return
+	}
+
+	opcode := instruction.name
+	if instruction.text != "" {
+		opcode += "   " + instruction.text
+	}
+	fmt.Fprintf(tracer.writer, "%04d %s:%-4d %-12s %-36s scope %d  [%s]\n", record.Offset, record.File, record.Line, function, opcode, record.Scope, strings.Join(stack, ", "))
+}
+
+func (tracer *Tracer) flush() error {
+	return tracer.writer.Flush()
+}
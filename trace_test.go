@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const traced_program = `(func twice [x int] int (return (* x 2)))
+(var total (twice 2))
+(println total)
+`
+
+func run_traced(t *testing.T, tracer *Tracer) {
+	register_natives_once.Do(register_natives)
+
+	var output bytes.Buffer
+	interpreter := new_Interpreter(PERMISSION_PURE)
+	interpreter.stdout = &output
+	interpreter.tracer = tracer
+	if result := interpreter.run_file(write_program(t, traced_program)); result != INTERPRETER_RESULT_OK {
+		t.Fatalf("The program failed with %v.", interpreter.err)
+	}
+	if output.String() != "4\n" {
+		t.Errorf("Tracing changed what the program printed to %q.", output.String())
+	}
+}
+
+func TestTraceFunction(t *testing.T) {
+	var trace bytes.Buffer
+	tracer := new_Tracer(&trace, false)
+	tracer.function = "twice"
+	run_traced(t, tracer)
+
+	var opcodes []string
+	for _, line := range strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "twice" {
+			t.Fatalf("Traced %q, which isn't in twice.", line)
+		}
+		opcodes = append(opcodes, fields[3])
+	}
+
+	want := "OP_START_SCOPE OP_STORE OP_LOAD OP_PUSH OP_MUL OP_RETURN"
+	if got := strings.Join(opcodes, " "); got != want {
+		t.Errorf("Traced %s, expected %s.", got, want)
+	}
+}
+
+func TestTraceLinesAsJSON(t *testing.T) {
+	var trace bytes.Buffer
+	tracer := new_Tracer(&trace, true)
+	if err := tracer.set_lines("3-"); err != nil {
+		t.Fatal(err)
+	}
+	run_traced(t, tracer)
+
+	var opcodes []string
+	for _, line := range strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n") {
+		var record Trace_Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("%q isn't a trace record: %v", line, err)
+		}
+		if record.Line < 3 || record.Function != "script" {
+			t.Errorf("Traced line %d of %s, expected only line 3 on of the script.", record.Line, record.Function)
+		}
+		opcodes = append(opcodes, record.Opcode)
+	}
+
+	// The end of the script is on the line after the last one.
+	want := "OP_LOAD OP_PRINTLN OP_EOF"
+	if got := strings.Join(opcodes, " "); got != want {
+		t.Errorf("Traced %s, expected %s.", got, want)
+	}
+}
+
+func TestTraceLineRanges(t *testing.T) {
+	for _, lines := range []string{"", "a", "10-5", "3-b"} {
+		if err := new_Tracer(&bytes.Buffer{}, false).set_lines(lines); err == nil {
+			t.Errorf("'%s' was accepted as a line range.", lines)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
 type ValueTypes byte
@@ -15,6 +16,10 @@ const (
 	BOOL
 	STRING
 	NO_VALUE
+	LIST
+
+	// Only used as the type of a variable, values themselves never have it.
+	ANY
 )
 
 func ValueTypes_to_string(type_ ValueTypes) string {
@@ -31,12 +36,25 @@ func ValueTypes_to_string(type_ ValueTypes) string {
 		return "string"
 	case NO_VALUE:
 		return "no value"
+	case LIST:
+		return "list"
+	case ANY:
+		return "any"
 
 	default:
 		return "Unknown"
 	}
 }
 
+// The name of the type with 'a' or 'an' in front of it, for messages.
+func ValueTypes_with_article(type_ ValueTypes) string {
+	name := ValueTypes_to_string(type_)
+	if strings.ContainsRune("aeiou", rune(name[0])) {
+		return "an " + name
+	}
+	return "a " + name
+}
+
 type Value struct {
 	value_type ValueTypes
 	as         struct {
@@ -45,25 +63,42 @@ type Value struct {
 		I64 int64
 		F64 float64
 		B1  bool
+		LST []Value
 	}
 }
 
-func print_Value(value Value) {
+func print_Value(writer io.Writer, value Value) {
+	io.WriteString(writer, value.String())
+}
+
+// How a value looks when it's printed, or turned into a string, like when it's
+// added to one. Decimals are as short as they can be while still reading back
+// as the same number.
+func (value Value) String() string {
 	switch value.value_type {
-	case DECIMAL:
-		fmt.Printf("%g", TO_DECIMAL_S(&value))
+	case UINT:
+		return strconv.FormatUint(value.as.U64, 10)
 	case INT:
-		fmt.Print(TO_INT_S(&value))
+		return strconv.FormatInt(value.as.I64, 10)
+	case DECIMAL:
+		return strconv.FormatFloat(value.as.F64, 'g', -1, 64)
 	case STRING:
-		fmt.Print(TO_STRING_S(&value))
-	case UINT:
-		fmt.Print(TO_UINT_S(&value))
+		return value.as.STR
 	case BOOL:
-		fmt.Print(TO_BOOL_S(&value))
+		return strconv.FormatBool(value.as.B1)
+
+	case LIST:
+		var elements []string
+		for _, v := range value.as.LST {
+			elements = append(elements, v.String())
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
 
 	case NO_VALUE:
-		fmt.Print("NO VAL")
+		return "none"
 	}
+
+	return "<" + ValueTypes_to_string(value.value_type) + ">"
 }
 
 type ValueArray struct {
@@ -97,6 +132,127 @@ func IS_OF_TYPE(value *Value, type_to_check ValueTypes) bool {
 	return value.value_type == type_to_check
 }
 
+// Binary operations promote both of their values to a common type, which is
+// found by walking up this lattice until both types meet:
+//
+//	uint -> int -> decimal
+//	uint, int, decimal, bool, list -> string
+//
+// Strings only support '+', where everything else gets converted to a string
+// and concatenated. Bools only combine with bools and lists only combine with
+// strings, any other pair has no common type and gives NO_VALUE.
+func promote_types(a ValueTypes, b ValueTypes) ValueTypes {
+	switch {
+	case a == b:
+		return a
+	case a == STRING || b == STRING:
+		return STRING
+	case a <= DECIMAL && b <= DECIMAL:
+		if a > b {
+			return a
+		}
+		return b
+	}
+
+	return NO_VALUE
+}
+
+// Whether implicitly converting the value to the type would lose information,
+// strict mode turns these conversions into errors. Going from an int or uint to
+// a decimal isn't counted, even though very large values lose precision.
+func is_lossy_conversion(value *Value, type_ ValueTypes) bool {
+	switch type_ {
+	case INT:
+		return IS_OF_TYPE(value, DECIMAL) || (IS_OF_TYPE(value, UINT) && value.as.U64 > math.MaxInt64)
+	case UINT:
+		return IS_OF_TYPE(value, DECIMAL) || (IS_OF_TYPE(value, INT) && value.as.I64 < 0)
+	}
+
+	return false
+}
+
+// Used by the explicit conversion forms like '(int x)', unlike coerce_Value this
+// can parse strings and turn bools into numbers. Returns false if the value
+// can't be converted.
+func convert_Value(value Value, type_ ValueTypes) (Value, bool) {
+	if type_ == value.value_type || type_ == ANY {
+		return value, true
+	}
+
+	if IS_OF_TYPE(&value, BOOL) && type_ != STRING {
+		if value.as.B1 {
+			value = INT_VAL(1)
+		} else {
+			value = INT_VAL(0)
+		}
+	}
+
+	switch type_ {
+	case INT:
+		if IS_OF_TYPE(&value, STRING) {
+			result, err := strconv.ParseInt(strings.TrimSpace(value.as.STR), 10, 64)
+			return INT_VAL(result), err == nil
+		}
+		return INT_VAL(TO_INT_S(&value)), IS_NUMBER(&value)
+
+	case UINT:
+		if IS_OF_TYPE(&value, STRING) {
+			result, err := strconv.ParseUint(strings.TrimSpace(value.as.STR), 10, 64)
+			return UINT_VAL(result), err == nil
+		}
+		return UINT_VAL(TO_UINT_S(&value)), IS_NUMBER(&value)
+
+	case DECIMAL:
+		if IS_OF_TYPE(&value, STRING) {
+			result, err := strconv.ParseFloat(strings.TrimSpace(value.as.STR), 64)
+			return DECIMAL_VAL(result), err == nil
+		}
+		return DECIMAL_VAL(TO_DECIMAL_S(&value)), IS_NUMBER(&value)
+
+	case BOOL:
+		if IS_OF_TYPE(&value, STRING) {
+			result, err := strconv.ParseBool(strings.TrimSpace(value.as.STR))
+			return BOOL_VAL(result), err == nil
+		}
+		return BOOL_VAL(IS_NUMBER(&value) && TO_DECIMAL_S(&value) != 0), IS_NUMBER(&value)
+
+	case STRING:
+		return STRING_VAL(TO_STRING_S(&value)), value.value_type != NO_VALUE
+	}
+
+	return value, false
+}
+
+func IS_NUMBER(value *Value) bool {
+	return IS_OF_TYPE(value, UINT) || IS_OF_TYPE(value, INT) || IS_OF_TYPE(value, DECIMAL)
+}
+
+// Converts a value to the type of the variable it is being stored in.
+// This is also where values coming out of an 'any' get their type checked.
+func coerce_Value(value Value, type_ ValueTypes) Value {
+	if type_ == value.value_type || type_ == ANY {
+		return value
+	}
+
+	if !IS_NUMBER(&value) {
+		runtime_error("Cannot convert %s to %s!", ValueTypes_with_article(value.value_type), ValueTypes_with_article(type_))
+	}
+
+	switch type_ {
+	case INT:
+		return INT_VAL(TO_INT_S(&value))
+	case UINT:
+		return UINT_VAL(TO_UINT_S(&value))
+	case DECIMAL:
+		return DECIMAL_VAL(TO_DECIMAL_S(&value))
+
+	default:
+		runtime_error("Cannot convert %s to %s!", ValueTypes_with_article(value.value_type), ValueTypes_with_article(type_))
+	}
+
+	return value
+}
+
 func TO_DECIMAL_S(value *Value) float64 {
 	if IS_OF_TYPE(value, UINT) {
 		return float64(value.as.U64)
@@ -105,7 +261,7 @@ func TO_DECIMAL_S(value *Value) float64 {
 	} else if IS_OF_TYPE(value, DECIMAL) {
 		return float64(value.as.F64)
 	} else {
-		log.Panic("Cannot convert value to a decimal!")
+		runtime_error("Cannot convert value to a decimal!")
 	}
 
 	return 0
@@ -119,7 +275,7 @@ func TO_INT_S(value *Value) int64 {
 	} else if IS_OF_TYPE(value, DECIMAL) {
 		return int64(value.as.F64)
 	} else {
-		log.Panic("Cannot convert value to a int!")
+		runtime_error("Cannot convert value to an int!")
 	}
 
 	return 0
@@ -133,7 +289,7 @@ func TO_UINT_S(value *Value) uint64 {
 	} else if IS_OF_TYPE(value, DECIMAL) {
 		return uint64(value.as.F64)
 	} else {
-		log.Panic("Cannot convert value to a uint!")
+		runtime_error("Cannot convert value to a uint!")
 	}
 
 	return 0
@@ -148,34 +304,14 @@ func TO_BOOL_S(value *Value) bool {
 		return value.as.B1
 
 	default:
-		log.Panic("Cannot convert value to a bool!")
+		runtime_error("Cannot convert value to a bool!")
 	}
 
 	return false
 }
 
 func TO_STRING_S(value *Value) string {
-	switch value.value_type {
-	case UINT:
-		return strconv.FormatUint(value.as.U64, 10)
-
-	case INT:
-		return strconv.FormatInt(value.as.I64, 10)
-
-	case DECIMAL:
-		return strconv.FormatFloat(value.as.F64, 'E', -1, 64)
-
-	case STRING:
-		return value.as.STR
-
-	case BOOL:
-		return strconv.FormatBool(value.as.B1)
-
-	default:
-		log.Panic("Cannot convert value to a string!")
-	}
-
-	return ""
+	return value.String()
 }
 
 func STRING_VAL(value string) Value {
@@ -187,12 +323,14 @@ func STRING_VAL(value string) Value {
 			I64 int64
 			F64 float64
 			B1  bool
+			LST []Value
 		}{
 			value,
 			0,
 			0,
 			0,
 			false,
+			nil,
 		},
 	}
 }
@@ -206,12 +344,14 @@ func NO_VAL() Value {
 			I64 int64
 			F64 float64
 			B1  bool
+			LST []Value
 		}{
 			"",
 			0,
 			0,
 			0,
 			false,
+			nil,
 		},
 	}
 }
@@ -225,12 +365,14 @@ func UINT_VAL(value uint64) Value {
 			I64 int64
 			F64 float64
 			B1  bool
+			LST []Value
 		}{
 			"",
 			value,
 			0,
 			0,
 			false,
+			nil,
 		},
 	}
 }
@@ -244,12 +386,14 @@ func INT_VAL(value int64) Value {
 			I64 int64
 			F64 float64
 			B1  bool
+			LST []Value
 		}{
 			"",
 			0,
 			value,
 			0,
 			false,
+			nil,
 		},
 	}
 }
@@ -263,12 +407,14 @@ func BOOL_VAL(value bool) Value {
 			I64 int64
 			F64 float64
 			B1  bool
+			LST []Value
 		}{
 			"",
 			0,
 			0,
 			0,
 			value,
+			nil,
 		},
 	}
 }
@@ -282,12 +428,35 @@ func DECIMAL_VAL(value float64) Value {
 			I64 int64
 			F64 float64
 			B1  bool
+			LST []Value
 		}{
 			"",
 			0,
 			0,
 			value,
 			false,
+			nil,
+		},
+	}
+}
+
+func LIST_VAL(values []Value) Value {
+	return Value{
+		LIST,
+		struct {
+			STR string
+			U64 uint64
+			I64 int64
+			F64 float64
+			B1  bool
+			LST []Value
+		}{
+			"",
+			0,
+			0,
+			0,
+			false,
+			values,
 		},
 	}
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 )
 
 type Function_Table struct {
@@ -16,13 +17,67 @@ const (
 	FUNCTION_VIRTUAL
 )
 
+// Used as the max arity of functions that take any amount of arguments.
+const ARITY_UNLIMITED = ^uint(0)
+
 type Function_Entry struct {
 	f_type      Function_Type
 	native_body func(bool, []Value) (Value, ValueTypes)
 	position    uint
 	name        string
-	arity       uint
+	module      string
+	min_arity   uint
+	max_arity   uint
+	defaults    []Value
+	arg_types   []ValueTypes
+	rest_type   ValueTypes
 	return_type ValueTypes
+	permission  Permissions
+}
+
+func (function *Function_Entry) is_variadic() bool {
+	return function.max_arity == ARITY_UNLIMITED
+}
+
+func (function *Function_Entry) accepts_arity(arity uint) bool {
+	return function.min_arity <= arity && arity <= function.max_arity
+}
+
+func (function *Function_Entry) arity_to_string() string {
+	switch {
+	case function.is_variadic():
+		return fmt.Sprintf("at least %d argument(s)", function.min_arity)
+	case function.min_arity == function.max_arity:
+		return fmt.Sprintf("%d argument(s)", function.min_arity)
+	default:
+		return fmt.Sprintf("%d to %d arguments", function.min_arity, function.max_arity)
+	}
+}
+
+// Lines up the arguments of a call with the parameters of a virtual function.
+// Missing trailing arguments get their default values and any extra arguments
+// are packed into a list for the rest parameter.
+func (function *Function_Entry) bind_arguments(values []Value) []Value {
+	fixed := function.min_arity + uint(len(function.defaults))
+	bound := make([]Value, 0, fixed+1)
+
+	for i := uint(0); i < fixed; i++ {
+		if i < uint(len(values)) {
+			bound = append(bound, values[i])
+		} else {
+			bound = append(bound, function.defaults[i-function.min_arity])
+		}
+	}
+
+	if function.is_variadic() {
+		rest := []Value{}
+		for i := fixed; i < uint(len(values)); i++ {
+			rest = append(rest, coerce_Value(values[i], function.rest_type))
+		}
+		bound = append(bound, LIST_VAL(rest))
+	}
+
+	return bound
 }
 
 var ftable = Function_Table{}
@@ -37,7 +92,11 @@ func (table *Function_Table) check_if_already_exists(name string) bool {
 	return false
 }
 
-func (table *Function_Table) add_virtual_entry(name string, position uint, arity uint, return_type ValueTypes) {
+// The defaults are for the trailing parameters after the first 'min_arity' ones,
+// and 'rest_type' is only used when 'max_arity' is ARITY_UNLIMITED. 'module' is
+// the prefix of the module the function is in, "" for the script.
+// The types of the arguments don't include the rest argument, that's 'rest_type'.
+func (table *Function_Table) add_virtual_entry(name string, module string, position uint, min_arity uint, max_arity uint, defaults []Value, arg_types []ValueTypes, rest_type ValueTypes, return_type ValueTypes) {
 	if table.check_if_already_exists(name) {
 		log.Panicf("Function '%s' already exists", name)
 	}
@@ -47,12 +106,24 @@ func (table *Function_Table) add_virtual_entry(name string, position uint, arity
 		func(b bool, v []Value) (Value, ValueTypes) { return Value{}, NO_VALUE },
 		position,
 		name,
-		arity,
+		module,
+		min_arity,
+		max_arity,
+		defaults,
+		arg_types,
+		rest_type,
 		return_type,
+		PERMISSION_PURE,
 	})
 }
 
-func (table *Function_Table) add_native_entry(name string, body func(bool, []Value) (Value, ValueTypes), arity uint, return_type ValueTypes) {
+func (table *Function_Table) add_native_entry(name string, body func(bool, []Value) (Value, ValueTypes), arity uint, return_type ValueTypes, permission Permissions) {
+	table.add_variadic_native_entry(name, body, arity, arity, return_type, permission)
+}
+
+// Natives get their arguments in the order they were written in, so they can
+// check len(values) themselves when they accept a range of arities.
+func (table *Function_Table) add_variadic_native_entry(name string, body func(bool, []Value) (Value, ValueTypes), min_arity uint, max_arity uint, return_type ValueTypes, permission Permissions) {
 	if table.check_if_already_exists(name) {
 		log.Panicf("Function '%s' already exists", name)
 	}
@@ -62,8 +133,14 @@ func (table *Function_Table) add_native_entry(name string, body func(bool, []Val
 		body,
 		0,
 		name,
-		arity,
+		"",
+		min_arity,
+		max_arity,
+		nil,
+		nil,
+		NO_VALUE,
 		return_type,
+		permission,
 	})
 }
 
@@ -74,13 +151,16 @@ func (table *Function_Table) get_entry(name string) Function_Entry {
 		}
 	}
 
-	log.Panicf("Cannot find an entry by the name of '%s'\n", name)
+	runtime_error("Cannot find an entry by the name of '%s'", name)
 	return Function_Entry{}
 }
 
 type Environment struct {
 	Entries      []Entry
 	currentScope uint8
+
+	// The prefix of the module these are the variables of, "" for the script.
+	module string
 }
 
 type Entry struct {
@@ -103,17 +183,17 @@ func (env *Environment) assign_to_entry(name string, value Value) {
 	for i := int16(env.currentScope); i >= 0; i-- {
 		for k, v := range env.Entries {
 			if v.scope == uint8(i) && v.name == name {
-				if v.value.value_type == value.value_type {
+				if v.vtype == ANY || v.value.value_type == value.value_type {
 					env.Entries[k].value = value
 				} else {
-					log.Panicf("Cannot assign value to '%s' as it's the wrong type.", name)
+					runtime_error("Cannot assign value to '%s' as it's the wrong type.", name)
 				}
 				return
 			}
 		}
 	}
 
-	log.Panicf("Couldn't get a variable by the name of '%s'!", name)
+	runtime_error("Couldn't get a variable by the name of '%s'!", name)
 }
 
 func (env *Environment) get_variable_value(name string) Value {
@@ -125,7 +205,7 @@ func (env *Environment) get_variable_value(name string) Value {
 		}
 	}
 
-	log.Panicf("Couldn't get a variable by the name of '%s'!", name)
+	runtime_error("Couldn't get a variable by the name of '%s'!", name)
 	return NO_VAL()
 }
 
@@ -159,11 +239,18 @@ func (env *Environment) print_entries() {
 		fmt.Print("  ")
 		fmt.Print(ValueTypes_to_string(env.Entries[i].vtype))
 		fmt.Print("  ")
-		print_Value(env.Entries[i].value)
+		print_Value(os.Stdout, env.Entries[i].value)
 		fmt.Println("]")
 	}
 }
 
+// Gives back a copy that can change without changing this one.
+func (env *Environment) copy() Environment {
+	result := *env
+	result.Entries = append([]Entry(nil), env.Entries...)
+	return result
+}
+
 func new_Environment() (result Environment) {
 	result = Environment{}
 	result.Entries = make([]Entry, 0, 0)
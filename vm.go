@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
-	"log"
+	"io"
+	"math"
+	"os"
+	"strings"
 )
 
 const (
@@ -13,6 +17,10 @@ const (
 
 var env Environment
 
+// Every VM reads from the same buffer, so what one of them read ahead isn't
+// lost to the next.
+var stdin = bufio.NewReader(os.Stdin)
+
 // The main purpose of this is to test out features of the compiler to see if they are implemented correctly
 // This will essentially emulate what I plan for the bytecode to be compiled to
 // It will also be used as the base for the passes in the compiler, like the infer pass or optimization pass
@@ -25,6 +33,24 @@ type VM struct {
 	evaluating              bool
 	function_starting_scope []uint8
 	function_jump_back      []uint32
+	function_names          []string
+	handlers                []Try_Handler
+	debugger                Debugger
+	tracer                  *Tracer
+	profiler                *Profiler
+	coverage                *Coverage
+	strict                  bool
+	checked                 bool
+	permissions             Permissions
+	err                     *Runtime_Error
+	limits                  Limits
+	instructions            uint64
+	allocated_bytes         uint64
+
+	// Where the script prints to and reads lines from.
+	stdout io.Writer
+	stderr io.Writer
+	stdin  *bufio.Reader
 }
 
 type InterpreterResult byte
@@ -33,8 +59,73 @@ const (
 	INTERPRETER_RESULT_OK InterpreterResult = iota
 	INTERPETER_RESULT_COMPILE_ERROR
 	INTERPRETER_RESULT_INTERPET_ERROR
+
+	// The script was stopped by one of the limits of the VM.
+	INTERPRETER_RESULT_INSTRUCTION_LIMIT
+	INTERPRETER_RESULT_TIMEOUT
+	INTERPRETER_RESULT_CANCELLED
+
+	// The script called '(exit n)', n is the status of the error.
+	INTERPRETER_RESULT_EXIT
 )
 
+type Runtime_Error struct {
+	message string
+	line    uint32
+	result  InterpreterResult
+
+	// What a catch gets, for runtime errors of the VM this is the message.
+	value Value
+
+	// The functions that were running, the innermost one first. This is empty
+	// when the error happened outside of any function.
+	trace []Stack_Frame
+
+	// What the script gave to '(exit n)'.
+	status int
+}
+
+type Stack_Frame struct {
+	function string
+	file     string
+	path     string
+	line     uint32
+}
+
+func (err *Runtime_Error) Error() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "[Line: %d] Runtime error: %s", err.line, err.message)
+	for _, frame := range err.trace {
+		fmt.Fprintf(&builder, "\n    at %s (%s:%d)", frame.function, frame.file, frame.line)
+	}
+	return builder.String()
+}
+
+// Stops the script that is running, the line gets filled in by run.
+func runtime_error(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	panic(&Runtime_Error{message, 0, INTERPRETER_RESULT_INTERPET_ERROR, STRING_VAL(message), nil, 0})
+}
+
+// Like runtime_error, but for when the script didn't do anything wrong and just
+// has to stop, so run gives back 'result' instead.
+func stop_execution(result InterpreterResult, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	panic(&Runtime_Error{message, 0, result, STRING_VAL(message), nil, 0})
+}
+
+// Stops the script the way '(exit n)' does, it's up to whoever ran it what the
+// status means.
+func exit_execution(status int) {
+	message := fmt.Sprintf("The script exited with %d.", status)
+	panic(&Runtime_Error{message, 0, INTERPRETER_RESULT_EXIT, STRING_VAL(message), nil, status})
+}
+
+// Raises a value from the script, which a catch gets as it is.
+func throw_Value(value Value) {
+	panic(&Runtime_Error{TO_STRING_S(&value), 0, INTERPRETER_RESULT_INTERPET_ERROR, value, nil, 0})
+}
+
 func new_VM(chunk *Chunk) (result VM) {
 	var valueStack ValueArray
 	init_ValueArray(&valueStack)
@@ -46,19 +137,41 @@ func new_VM(chunk *Chunk) (result VM) {
 		false,
 		[]uint8{},
 		[]uint32{},
+		[]string{},
+		[]Try_Handler{},
+		nil,
+		nil,
+		nil,
+		nil,
+		false,
+		false,
+		// Only the pure natives, anything more has to be allowed by the Interpreter.
+		PERMISSION_PURE,
+		nil,
+		Limits{},
+		0,
+		0,
+		os.Stdout,
+		os.Stderr,
+		stdin,
 	}
 	return
 }
 
-func (vm *VM) evaluate_operation() (result ValueTypes) {
+func (vm *VM) evaluate_operation() (result Value, err *Runtime_Error) {
 	vm.evaluating = true
-	interpret(vm)
+	interpret_result := run(vm)
 	vm.evaluating = false
-	env.remove_scope(env.currentScope)
-	env.currentScope--
+
+	// Everything runs again from the start the next time, and it might have
+	// stopped inside of a module.
+	env = new_Environment()
+	if interpret_result != INTERPRETER_RESULT_OK {
+		return NO_VAL(), vm.err
+	}
 
 	vm.index = 0
-	result = pop_ValueArray(&vm.stack).value_type
+	result = pop_ValueArray(&vm.stack)
 	free_ValueArray(&vm.stack)
 	return
 }
@@ -67,30 +180,190 @@ func (vm *VM) evaluate_function(name string, values []Value) (result Value, retu
 	function := ftable.get_entry(name)
 
 	if function.f_type == FUNCTION_VIRTUAL {
+		vm.check_call_depth()
+
+		// A function of another module runs with the variables of its module.
+		if function.module != env.module {
+			outer := switch_Environment(function.module)
+			scope := env.currentScope
+			defer func() {
+				// An error leaves the scopes of the function behind.
+				for env.currentScope > scope {
+					env.remove_scope(env.currentScope)
+					env.currentScope--
+				}
+				switch_Environment(outer)
+			}()
+		}
 
 		vm.type_to_check = VM_TYPE_FUNCTION
 		vm.function_starting_scope = append(vm.function_starting_scope, env.currentScope+1)
 		vm.function_jump_back = append(vm.function_jump_back, vm.index)
-		for _, v := range values {
-			write_ValueArray(&vm.stack, v)
+		vm.function_names = append(vm.function_names, function.name)
+
+		// The parameters are stored in order, so the first one has to be on top.
+		arguments := function.bind_arguments(values)
+		if function.is_variadic() {
+			vm.allocated(&arguments[len(arguments)-1])
+		}
+		for i := len(arguments) - 1; i >= 0; i-- {
+			write_ValueArray(&vm.stack, arguments[i])
 		}
 		vm.index = uint32(function.position)
 		interpret(vm)
 
 		if function.return_type != NO_VALUE {
-			result = pop_ValueArray(&vm.stack)
+			result = vm.implicit_conversion(pop_ValueArray(&vm.stack), function.return_type)
 			returned_type = result.value_type
 		} else {
 			result = Value{}
 			returned_type = NO_VALUE
 		}
 	} else if function.f_type == FUNCTION_NATIVE {
+		native_limits = vm.limits
 		result, returned_type = function.native_body(vm.evaluating, values)
+		vm.allocated(&result)
 	}
 
 	return
 }
 
+func (vm *VM) implicit_conversion(value Value, type_ ValueTypes) Value {
+	if vm.strict && is_lossy_conversion(&value, type_) {
+		runtime_error("Strict mode doesn't allow implicitly converting %s to %s.", ValueTypes_with_article(value.value_type), ValueTypes_with_article(type_))
+	}
+
+	return coerce_Value(value, type_)
+}
+
+// Interprets the chunk, turning runtime errors into INTERPRETER_RESULT_INTERPET_ERROR,
+// or the result of the limit that stopped it. The error is left in vm.err.
+func run(vm *VM) (result InterpreterResult) {
+	defer vm.catch_error(&result)
+	return interpret(vm)
+}
+
+// Calls a virtual function without arguments from outside of the script, the
+// way 'tesp test' runs tests.
+func run_function(vm *VM, name string) (result InterpreterResult) {
+	defer vm.catch_error(&result)
+	vm.index = uint32(len(vm.chunk.code))
+	vm.evaluate_function(name, nil)
+	return INTERPRETER_RESULT_OK
+}
+
+func (vm *VM) catch_error(result *InterpreterResult) {
+	if recovered := recover(); recovered != nil {
+		err, ok := recovered.(*Runtime_Error)
+		if !ok {
+			panic(recovered)
+		}
+
+		if vm.index > 0 && int(vm.index) <= len(vm.chunk.lines) {
+			err.line = vm.chunk.lines[vm.index-1]
+		}
+		if len(vm.function_jump_back) > 0 {
+			err.trace = vm.stack_trace(vm.index - 1)
+		}
+
+		vm.err = err
+		vm.function_starting_scope = vm.function_starting_scope[:0]
+		vm.function_jump_back = vm.function_jump_back[:0]
+		vm.function_names = vm.function_names[:0]
+		vm.handlers = vm.handlers[:0]
+		vm.type_to_check = VM_TYPE_SCRIPT
+		*result = err.result
+	}
+}
+
+// Walks the functions that are running, from the instruction at 'offset' back
+// through where each function was called from.
+func (vm *VM) stack_trace(offset uint32) []Stack_Frame {
+	var trace []Stack_Frame
+
+	for i := len(vm.function_names) - 1; i >= -1; i-- {
+		function := "script"
+		if i >= 0 {
+			function = vm.function_names[i]
+		}
+
+		line := uint32(0)
+		if int(offset) < len(vm.chunk.lines) {
+			line = vm.chunk.lines[offset]
+		}
+		file := vm.chunk.file_at(offset)
+		trace = append(trace, Stack_Frame{function, file.name, file.path, line})
+
+		// The return address is just after the call, the call itself is on the
+		// line before it.
+		if i >= 0 {
+			offset = vm.function_jump_back[i] - 1
+		}
+	}
+
+	return trace
+}
+
+// Where to go back to when the body of a try fails.
+type Try_Handler struct {
+	catch_index   uint32
+	stack_height  int
+	scope         uint8
+	module        string
+	call_depth    int
+	type_to_check byte
+}
+
+// Runs the body of a try, which ends at its OP_END_TRY. If it fails the VM
+// gets unwound back to how it was when the try started, and continues at the
+// catch with the error on the stack. Gives back true if the body returned from
+// the function the try is in.
+func (vm *VM) try_block(catch_index uint32) (returned bool) {
+	handler := Try_Handler{catch_index, len(vm.stack.values), env.currentScope, env.module, len(vm.function_jump_back), vm.type_to_check}
+	vm.handlers = append(vm.handlers, handler)
+
+	defer func() {
+		vm.handlers = vm.handlers[0 : len(vm.handlers)-1]
+
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		// Only errors can be caught, running out of a limit still stops the script.
+		err, ok := recovered.(*Runtime_Error)
+		if !ok || err.result != INTERPRETER_RESULT_INTERPET_ERROR {
+			panic(recovered)
+		}
+
+		vm.unwind(&handler)
+		write_ValueArray(&vm.stack, err.value)
+		vm.index = handler.catch_index
+		returned = false
+	}()
+
+	interpret(vm)
+	return len(vm.function_jump_back) < handler.call_depth
+}
+
+func (vm *VM) unwind(handler *Try_Handler) {
+	vm.stack.values = vm.stack.values[0:handler.stack_height]
+
+	// The body might have failed while a module it imported was running.
+	if env.module != handler.module {
+		switch_Environment(handler.module)
+	}
+	for env.currentScope > handler.scope {
+		env.remove_scope(env.currentScope)
+		env.currentScope--
+	}
+
+	vm.function_starting_scope = vm.function_starting_scope[0:handler.call_depth]
+	vm.function_jump_back = vm.function_jump_back[0:handler.call_depth]
+	vm.function_names = vm.function_names[0:handler.call_depth]
+	vm.type_to_check = handler.type_to_check
+}
+
 func interpret(vm *VM) InterpreterResult {
 	READ_BYTE := func() (result byte) {
 
@@ -103,14 +376,10 @@ func interpret(vm *VM) InterpreterResult {
 		b = pop_ValueArray(&vm.stack)
 		a = pop_ValueArray(&vm.stack)
 
-		types = 0
-
-		if types < a.value_type {
-			types = a.value_type
-		}
+		types = promote_types(a.value_type, b.value_type)
 
-		if types < b.value_type {
-			types = b.value_type
+		if vm.strict && (is_lossy_conversion(&a, types) || is_lossy_conversion(&b, types)) {
+			runtime_error("Strict mode doesn't allow implicitly converting %s and %s to %s.", ValueTypes_with_article(a.value_type), ValueTypes_with_article(b.value_type), ValueTypes_with_article(types))
 		}
 
 		return
@@ -121,33 +390,24 @@ func interpret(vm *VM) InterpreterResult {
 		return vm.chunk.constants.values[index]
 	}
 
-	// I guess this is my only solution for debugging.
-	// ¯\_(ツ)_/¯
-	debugging := false
-	debug_entries := true
-	debug_values := true
-
 	for {
 		if len(vm.chunk.code) == int(vm.index) {
 			return INTERPRETER_RESULT_OK
 		}
 
-		if debugging {
-			if debug_entries {
-				env.print_entries()
-				fmt.Println()
-			}
-
-			if debug_values {
-				fmt.Println("===       Stack       ===")
-				for _, v := range vm.stack.values {
-					print_Value(v)
-					fmt.Println(" ", ValueTypes_to_string(v.value_type), " ")
-				}
-				fmt.Println()
-			}
+		vm.check_limits()
+		if vm.debugger != nil {
+			vm.debugger.Before_Instruction(vm)
+		}
+		if vm.tracer != nil {
+			vm.tracer.trace(vm)
+		}
+		if vm.profiler != nil {
+			vm.profiler.before_instruction(vm)
+		}
+		if vm.coverage != nil {
+			vm.coverage.before_instruction(vm)
 		}
-
 		instruction := READ_BYTE()
 
 		switch instruction {
@@ -157,6 +417,30 @@ func interpret(vm *VM) InterpreterResult {
 			env.remove_scope(env.currentScope)
 			env.currentScope--
 
+		case OP_START_MODULE:
+			var bytes_of_name []byte
+			current_byte := READ_BYTE()
+			for i := 0; current_byte != OP_END_QUOTE; i++ {
+				bytes_of_name = append(bytes_of_name, current_byte)
+				current_byte = READ_BYTE()
+			}
+
+			// The module starts with no variables, the ones it defines stay for
+			// its functions.
+			module_environments[env.module] = env
+			env = new_Environment()
+			env.module = string(bytes_of_name)
+
+		case OP_END_MODULE:
+			var bytes_of_name []byte
+			current_byte := READ_BYTE()
+			for i := 0; current_byte != OP_END_QUOTE; i++ {
+				bytes_of_name = append(bytes_of_name, current_byte)
+				current_byte = READ_BYTE()
+			}
+
+			switch_Environment(string(bytes_of_name))
+
 		case OP_ASSIGN:
 			var bytes_of_name []byte
 			current_byte := READ_BYTE()
@@ -177,11 +461,21 @@ func interpret(vm *VM) InterpreterResult {
 			}
 			name := string(bytes_of_name)
 
+			arity := uint(READ_BYTE())
+
+			// The compiler only checks the functions that existed when it got to
+			// the call, so natives are checked again here.
 			function := ftable.get_entry(name)
-			var values []Value
+			if function.f_type == FUNCTION_NATIVE && !vm.permissions.allows(function.permission) {
+				runtime_error("'%s' needs the %s permission, which this script doesn't have.", name, function.permission)
+			}
+			if !function.accepts_arity(arity) {
+				runtime_error("Function '%s' expects %s, but got %d.", name, function.arity_to_string(), arity)
+			}
 
-			for i := 0; uint(i) < function.arity; i++ {
-				values = append(values, pop_ValueArray(&vm.stack))
+			values := make([]Value, arity)
+			for i := int(arity) - 1; i >= 0; i-- {
+				values[i] = pop_ValueArray(&vm.stack)
 			}
 
 			value, rtype := vm.evaluate_function(name, values)
@@ -202,7 +496,7 @@ func interpret(vm *VM) InterpreterResult {
 			value := binary.BigEndian.Uint32([]byte{READ_BYTE(), READ_BYTE(), READ_BYTE(), READ_BYTE()})
 
 			if !IS_OF_TYPE(&result, BOOL) {
-				log.Panic("Boolean value is required for an If False Jump instruction.")
+				runtime_error("Boolean value is required for an If False Jump instruction.")
 			}
 
 			if !TO_BOOL_S(&result) {
@@ -213,16 +507,39 @@ func interpret(vm *VM) InterpreterResult {
 			value := pop_ValueArray(&vm.stack)
 
 			switch value.value_type {
-			case INT:
-			case UINT:
-				write_ValueArray(&vm.stack, INT_VAL(-TO_INT_S(&value)))
+			case INT, UINT:
+				// Negating a uint gives back an int, as a uint can't hold the result.
+				result, ok := sub_int64(0, TO_INT_S(&value))
+				check_overflow(ok && !(IS_OF_TYPE(&value, UINT) && value.as.U64 > math.MaxInt64), "-", INT)
+				write_ValueArray(&vm.stack, INT_VAL(result))
 
 			case DECIMAL:
 				write_ValueArray(&vm.stack, DECIMAL_VAL(-TO_DECIMAL_S(&value)))
 
 			default:
-				log.Panic("Value cannot be negated!")
+				runtime_error("Value cannot be negated!")
+			}
+
+		case OP_IS_TYPE:
+			value := pop_ValueArray(&vm.stack)
+			value_type := ValueTypes(READ_BYTE())
+
+			write_ValueArray(&vm.stack, BOOL_VAL(value_type == ANY || value.value_type == value_type))
+
+		case OP_CONVERT:
+			value := pop_ValueArray(&vm.stack)
+			value_type := ValueTypes(READ_BYTE())
+
+			converted, ok := convert_Value(value, value_type)
+			if !ok && IS_OF_TYPE(&value, STRING) {
+				runtime_error("Cannot convert the string '%s' to %s!", value.as.STR, ValueTypes_with_article(value_type))
+			} else if !ok {
+				runtime_error("Cannot convert %s to %s!", ValueTypes_with_article(value.value_type), ValueTypes_with_article(value_type))
 			}
+			if value.value_type != value_type {
+				vm.allocated(&converted)
+			}
+			write_ValueArray(&vm.stack, converted)
 
 		case OP_CMP_LESS:
 			a, b, value_type := GENERATE_VALUE_FOR_BINARY_OP()
@@ -236,7 +553,7 @@ func interpret(vm *VM) InterpreterResult {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_DECIMAL_S(&a) < TO_DECIMAL_S(&b)))
 
 			default:
-				log.Panic("Cannot compare (CMP_LESS) these two values!")
+				runtime_error("Cannot compare (CMP_LESS) these two values!")
 			}
 
 		case OP_CMP_GREATER:
@@ -251,7 +568,7 @@ func interpret(vm *VM) InterpreterResult {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_DECIMAL_S(&a) > TO_DECIMAL_S(&b)))
 
 			default:
-				log.Panic("Cannot compare (CMP_GREATER) these two values!")
+				runtime_error("Cannot compare (CMP_GREATER) these two values!")
 			}
 
 		case OP_CMP_EQUAL:
@@ -266,7 +583,7 @@ func interpret(vm *VM) InterpreterResult {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_DECIMAL_S(&a) == TO_DECIMAL_S(&b)))
 
 			default:
-				log.Panic("Cannot compare (CMP_EQUAL) these two values!")
+				runtime_error("Cannot compare (CMP_EQUAL) these two values!")
 			}
 
 		case OP_CMP_NOT_EQUAL:
@@ -281,7 +598,7 @@ func interpret(vm *VM) InterpreterResult {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_DECIMAL_S(&a) != TO_DECIMAL_S(&b)))
 
 			default:
-				log.Panic("Cannot compare (CMP_NOT_EQUAL) these two values!")
+				runtime_error("Cannot compare (CMP_NOT_EQUAL) these two values!")
 			}
 
 		case OP_CMP_LESS_EQUAL:
@@ -296,7 +613,7 @@ func interpret(vm *VM) InterpreterResult {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_DECIMAL_S(&a) <= TO_DECIMAL_S(&b)))
 
 			default:
-				log.Panic("Cannot compare (CMP_LESS_EQUAL) these two values!")
+				runtime_error("Cannot compare (CMP_LESS_EQUAL) these two values!")
 			}
 
 		case OP_CMP_GREATER_EQUAL:
@@ -311,14 +628,14 @@ func interpret(vm *VM) InterpreterResult {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_DECIMAL_S(&a) >= TO_DECIMAL_S(&b)))
 
 			default:
-				log.Panic("Cannot compare (CMP_GREATER_EQUAL) these two values!")
+				runtime_error("Cannot compare (CMP_GREATER_EQUAL) these two values!")
 			}
 
 		case OP_CMP_AND:
 			a, b, _ := GENERATE_VALUE_FOR_BINARY_OP()
 
 			if a.value_type != BOOL && b.value_type != BOOL {
-				log.Panic("The two values are not booleans, for an 'and' operation it is required to have two booleans")
+				runtime_error("The two values are not booleans, for an 'and' operation it is required to have two booleans")
 			} else {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_BOOL_S(&a) && TO_BOOL_S(&b)))
 			}
@@ -327,7 +644,7 @@ func interpret(vm *VM) InterpreterResult {
 			a, b, _ := GENERATE_VALUE_FOR_BINARY_OP()
 
 			if a.value_type != BOOL && b.value_type != BOOL {
-				log.Panic("The two values are not booleans, for an 'or' operation it is required to have two booleans")
+				runtime_error("The two values are not booleans, for an 'or' operation it is required to have two booleans")
 			} else {
 				write_ValueArray(&vm.stack, BOOL_VAL(TO_BOOL_S(&a) || TO_BOOL_S(&b)))
 			}
@@ -338,16 +655,22 @@ func interpret(vm *VM) InterpreterResult {
 
 			switch value_type {
 			case INT:
-				write_ValueArray(&vm.stack, INT_VAL(TO_INT_S(&a)+TO_INT_S(&b)))
+				result, ok := add_int64(TO_INT_S(&a), TO_INT_S(&b))
+				check_overflow(ok, "+", INT)
+				write_ValueArray(&vm.stack, INT_VAL(result))
 			case UINT:
-				write_ValueArray(&vm.stack, UINT_VAL(TO_UINT_S(&a)+TO_UINT_S(&b)))
+				result, ok := add_uint64(TO_UINT_S(&a), TO_UINT_S(&b))
+				check_overflow(ok, "+", UINT)
+				write_ValueArray(&vm.stack, UINT_VAL(result))
 			case DECIMAL:
-				write_ValueArray(&vm.stack, DECIMAL_VAL(TO_DECIMAL_S(&a)+TO_DECIMAL_S(&b)))
+				write_ValueArray(&vm.stack, DECIMAL_VAL(vm.check_decimal(TO_DECIMAL_S(&a)+TO_DECIMAL_S(&b), "+")))
 			case STRING:
-				write_ValueArray(&vm.stack, STRING_VAL(TO_STRING_S(&a)+TO_STRING_S(&b)))
+				result := STRING_VAL(TO_STRING_S(&a) + TO_STRING_S(&b))
+				vm.allocated(&result)
+				write_ValueArray(&vm.stack, result)
 
 			default:
-				log.Panic("Cannot add these two binary operations!")
+				runtime_error("Cannot add these two binary operations!")
 			}
 
 		case OP_SUB:
@@ -355,14 +678,18 @@ func interpret(vm *VM) InterpreterResult {
 
 			switch value_type {
 			case INT:
-				write_ValueArray(&vm.stack, INT_VAL(TO_INT_S(&a)-TO_INT_S(&b)))
+				result, ok := sub_int64(TO_INT_S(&a), TO_INT_S(&b))
+				check_overflow(ok, "-", INT)
+				write_ValueArray(&vm.stack, INT_VAL(result))
 			case UINT:
-				write_ValueArray(&vm.stack, UINT_VAL(TO_UINT_S(&a)-TO_UINT_S(&b)))
+				result, ok := sub_uint64(TO_UINT_S(&a), TO_UINT_S(&b))
+				check_overflow(ok, "-", UINT)
+				write_ValueArray(&vm.stack, UINT_VAL(result))
 			case DECIMAL:
-				write_ValueArray(&vm.stack, DECIMAL_VAL(TO_DECIMAL_S(&a)-TO_DECIMAL_S(&b)))
+				write_ValueArray(&vm.stack, DECIMAL_VAL(vm.check_decimal(TO_DECIMAL_S(&a)-TO_DECIMAL_S(&b), "-")))
 
 			default:
-				log.Panic("Cannot subtract these two binary operations!")
+				runtime_error("Cannot subtract these two binary operations!")
 			}
 
 		case OP_MUL:
@@ -370,14 +697,18 @@ func interpret(vm *VM) InterpreterResult {
 
 			switch value_type {
 			case INT:
-				write_ValueArray(&vm.stack, INT_VAL(TO_INT_S(&a)*TO_INT_S(&b)))
+				result, ok := mul_int64(TO_INT_S(&a), TO_INT_S(&b))
+				check_overflow(ok, "*", INT)
+				write_ValueArray(&vm.stack, INT_VAL(result))
 			case UINT:
-				write_ValueArray(&vm.stack, UINT_VAL(TO_UINT_S(&a)*TO_UINT_S(&b)))
+				result, ok := mul_uint64(TO_UINT_S(&a), TO_UINT_S(&b))
+				check_overflow(ok, "*", UINT)
+				write_ValueArray(&vm.stack, UINT_VAL(result))
 			case DECIMAL:
-				write_ValueArray(&vm.stack, DECIMAL_VAL(TO_DECIMAL_S(&a)*TO_DECIMAL_S(&b)))
+				write_ValueArray(&vm.stack, DECIMAL_VAL(vm.check_decimal(TO_DECIMAL_S(&a)*TO_DECIMAL_S(&b), "*")))
 
 			default:
-				log.Panic("Cannot mutliple these two binary operations!")
+				runtime_error("Cannot mutliple these two binary operations!")
 			}
 
 		case OP_DIV:
@@ -385,14 +716,131 @@ func interpret(vm *VM) InterpreterResult {
 
 			switch value_type {
 			case INT:
-				write_ValueArray(&vm.stack, INT_VAL(TO_INT_S(&a)/TO_INT_S(&b)))
+				check_divisor(&b)
+				result, ok := div_int64(TO_INT_S(&a), TO_INT_S(&b))
+				check_overflow(ok, "/", INT)
+				write_ValueArray(&vm.stack, INT_VAL(result))
 			case UINT:
+				check_divisor(&b)
 				write_ValueArray(&vm.stack, UINT_VAL(TO_UINT_S(&a)/TO_UINT_S(&b)))
 			case DECIMAL:
-				write_ValueArray(&vm.stack, DECIMAL_VAL(TO_DECIMAL_S(&a)/TO_DECIMAL_S(&b)))
+				write_ValueArray(&vm.stack, DECIMAL_VAL(vm.check_decimal(TO_DECIMAL_S(&a)/TO_DECIMAL_S(&b), "/")))
+
+			default:
+				runtime_error("Cannot divide these two binary operations!")
+			}
+
+		case OP_MOD:
+			a, b, value_type := GENERATE_VALUE_FOR_BINARY_OP()
+
+			switch value_type {
+			case INT:
+				check_divisor(&b)
+				write_ValueArray(&vm.stack, INT_VAL(TO_INT_S(&a)%TO_INT_S(&b)))
+			case UINT:
+				check_divisor(&b)
+				write_ValueArray(&vm.stack, UINT_VAL(TO_UINT_S(&a)%TO_UINT_S(&b)))
+			case DECIMAL:
+				write_ValueArray(&vm.stack, DECIMAL_VAL(vm.check_decimal(math.Mod(TO_DECIMAL_S(&a), TO_DECIMAL_S(&b)), "%")))
+
+			default:
+				runtime_error("Cannot get the remainder of these two binary operations!")
+			}
+
+		case OP_FLOOR_DIV:
+			a, b, value_type := GENERATE_VALUE_FOR_BINARY_OP()
+
+			switch value_type {
+			case INT:
+				check_divisor(&b)
+				result, ok := floor_div_int64(TO_INT_S(&a), TO_INT_S(&b))
+				check_overflow(ok, "div", INT)
+				write_ValueArray(&vm.stack, INT_VAL(result))
+			case UINT:
+				check_divisor(&b)
+				write_ValueArray(&vm.stack, UINT_VAL(TO_UINT_S(&a)/TO_UINT_S(&b)))
+			case DECIMAL:
+				write_ValueArray(&vm.stack, DECIMAL_VAL(vm.check_decimal(math.Floor(TO_DECIMAL_S(&a)/TO_DECIMAL_S(&b)), "div")))
+
+			default:
+				runtime_error("Cannot floor divide these two binary operations!")
+			}
+
+		case OP_POW:
+			a, b, value_type := GENERATE_VALUE_FOR_BINARY_OP()
+
+			switch value_type {
+			case INT:
+				if TO_INT_S(&b) < 0 {
+					runtime_error("Cannot raise an int to a negative power, use a decimal instead.")
+				}
+				result, ok := pow_int64(TO_INT_S(&a), TO_INT_S(&b))
+				check_overflow(ok, "**", INT)
+				write_ValueArray(&vm.stack, INT_VAL(result))
+			case UINT:
+				result, ok := pow_uint64(TO_UINT_S(&a), TO_UINT_S(&b))
+				check_overflow(ok, "**", UINT)
+				write_ValueArray(&vm.stack, UINT_VAL(result))
+			case DECIMAL:
+				write_ValueArray(&vm.stack, DECIMAL_VAL(vm.check_decimal(math.Pow(TO_DECIMAL_S(&a), TO_DECIMAL_S(&b)), "**")))
+
+			default:
+				runtime_error("Cannot raise these two binary operations to a power!")
+			}
+
+		case OP_BIT_AND, OP_BIT_OR, OP_BIT_XOR:
+			a, b, value_type := GENERATE_VALUE_FOR_BINARY_OP()
+			if value_type != INT && value_type != UINT {
+				runtime_error("Bitwise operations only work on ints and uints!")
+			}
+
+			var result uint64
+			switch instruction {
+			case OP_BIT_AND:
+				result = TO_UINT_S(&a) & TO_UINT_S(&b)
+			case OP_BIT_OR:
+				result = TO_UINT_S(&a) | TO_UINT_S(&b)
+			case OP_BIT_XOR:
+				result = TO_UINT_S(&a) ^ TO_UINT_S(&b)
+			}
+
+			if value_type == INT {
+				write_ValueArray(&vm.stack, INT_VAL(int64(result)))
+			} else {
+				write_ValueArray(&vm.stack, UINT_VAL(result))
+			}
+
+		// Shifts keep the type of the value being shifted, instead of promoting.
+		// Bits shifted off the top are dropped, unless arithmetic is checked.
+		case OP_SHIFT_LEFT, OP_SHIFT_RIGHT:
+			amount := pop_ValueArray(&vm.stack)
+			value := pop_ValueArray(&vm.stack)
+			shift := shift_amount(&amount)
+
+			switch value.value_type {
+			case INT:
+				if instruction == OP_SHIFT_LEFT {
+					result := value.as.I64 << shift
+					if vm.checked {
+						check_overflow(value.as.I64 == 0 || (shift < 64 && result>>shift == value.as.I64), "<<", INT)
+					}
+					write_ValueArray(&vm.stack, INT_VAL(result))
+				} else {
+					write_ValueArray(&vm.stack, INT_VAL(value.as.I64>>shift))
+				}
+			case UINT:
+				if instruction == OP_SHIFT_LEFT {
+					result := value.as.U64 << shift
+					if vm.checked {
+						check_overflow(value.as.U64 == 0 || (shift < 64 && result>>shift == value.as.U64), "<<", UINT)
+					}
+					write_ValueArray(&vm.stack, UINT_VAL(result))
+				} else {
+					write_ValueArray(&vm.stack, UINT_VAL(value.as.U64>>shift))
+				}
 
 			default:
-				log.Panic("Cannot divide these two binary operations!")
+				runtime_error("Bitwise operations only work on ints and uints!")
 			}
 
 		case OP_LOAD:
@@ -417,43 +865,52 @@ func interpret(vm *VM) InterpreterResult {
 
 			current_name := string(bytes_of_name)
 			current_type := ValueTypes(READ_BYTE())
-			current_value := pop_ValueArray(&vm.stack)
-
-			if current_type != current_value.value_type {
-				switch current_type {
-				case INT:
-					current_value = INT_VAL(TO_INT_S(&current_value))
-				case UINT:
-					current_value = UINT_VAL(TO_UINT_S(&current_value))
-				case DECIMAL:
-					current_value = DECIMAL_VAL(TO_DECIMAL_S(&current_value))
-				case BOOL:
-
-				default:
-					log.Panic("Unknown type used for variable!")
-				}
-			}
+			current_value := vm.implicit_conversion(pop_ValueArray(&vm.stack), current_type)
 
 			env.add_entry(current_name, current_type, current_value, env.currentScope)
 
 		case OP_PUSH:
 			write_ValueArray(&vm.stack, READ_CONSTANT())
 
+		case OP_PUSH_LONG:
+			index := uint32(READ_BYTE())<<16 | uint32(READ_BYTE())<<8 | uint32(READ_BYTE())
+			write_ValueArray(&vm.stack, vm.chunk.constants.values[index])
+
 		case OP_PRINT:
 			if !vm.evaluating {
-				print_Value(pop_ValueArray(&vm.stack))
+				print_Value(vm.stdout, pop_ValueArray(&vm.stack))
 			}
 
 		case OP_PRINTLN:
 			if !vm.evaluating {
-				print_Value(pop_ValueArray(&vm.stack))
-				fmt.Println()
+				print_Value(vm.stdout, pop_ValueArray(&vm.stack))
+				fmt.Fprintln(vm.stdout)
+			}
+
+		case OP_EPRINTLN:
+			if !vm.evaluating {
+				print_Value(vm.stderr, pop_ValueArray(&vm.stack))
+				fmt.Fprintln(vm.stderr)
 			}
 
+		case OP_READ_LINE:
+			// The line without its newline, or "" once the input has ended.
+			line := ""
+			if !vm.evaluating {
+				text, err := vm.stdin.ReadString('\n')
+				if err != nil && err != io.EOF {
+					runtime_error("Cannot read a line: %s", err)
+				}
+				line = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+			}
+
+			value := STRING_VAL(line)
+			vm.allocated(&value)
+			write_ValueArray(&vm.stack, value)
+
 		case OP_RETURN:
 			if vm.type_to_check == VM_TYPE_SCRIPT {
-				fmt.Println("Cannot return in a script!")
-				return INTERPRETER_RESULT_INTERPET_ERROR
+				runtime_error("Cannot return in a script!")
 			} else if vm.type_to_check == VM_TYPE_FUNCTION {
 
 				env.remove_scope(vm.function_starting_scope[len(vm.function_starting_scope)-1])
@@ -463,6 +920,7 @@ func interpret(vm *VM) InterpreterResult {
 
 				vm.function_starting_scope = vm.function_starting_scope[0 : len(vm.function_starting_scope)-1]
 				vm.function_jump_back = vm.function_jump_back[0 : len(vm.function_jump_back)-1]
+				vm.function_names = vm.function_names[0 : len(vm.function_names)-1]
 
 				if len(vm.function_jump_back) == 0 {
 					vm.type_to_check = VM_TYPE_SCRIPT
@@ -471,12 +929,23 @@ func interpret(vm *VM) InterpreterResult {
 				return INTERPRETER_RESULT_OK
 			}
 
+		case OP_TRY:
+			catch_index := binary.BigEndian.Uint32([]byte{READ_BYTE(), READ_BYTE(), READ_BYTE(), READ_BYTE()})
+			if vm.try_block(catch_index) {
+				return INTERPRETER_RESULT_OK
+			}
+
+		case OP_END_TRY:
+			return INTERPRETER_RESULT_OK
+
+		case OP_THROW:
+			throw_Value(pop_ValueArray(&vm.stack))
+
 		case OP_EOF:
 			return INTERPRETER_RESULT_OK
 
 		default:
-			log.Printf("Opcode used at %d and of type %d in bytecode doesn't have implementation or isn't correct.\n", vm.index, instruction)
-			return INTERPRETER_RESULT_INTERPET_ERROR
+			runtime_error("Opcode used at %d and of type %d in bytecode doesn't have implementation or isn't correct.", vm.index, instruction)
 		}
 	}
 }